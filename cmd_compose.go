@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/zemm-io/zemm/compose"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/project"
+	"github.com/zemm-io/zemm/runtime"
+)
+
+// generateCompose writes the compose file of the project or prints it on dryRun
+func generateCompose(dryRun bool) error {
+	p, err := project.Load(zemmPWD)
+	if err != nil {
+		return err
+	}
+
+	lock, err := install.ReadLock(p.Dir())
+	if err != nil {
+		return err
+	}
+	if len(lock.Packages) == 0 {
+		return fmt.Errorf("No packages installed, run \"zemm install\" first")
+	}
+
+	if dryRun {
+		data, err := compose.Generate(p, lock, true)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+		return nil
+	}
+
+	return compose.Write(p, lock)
+}
+
+func newRuntime(name string) (runtime.Runtime, error) {
+	if name == "" {
+		name = os.Getenv("ZEMM_RUNTIME")
+	}
+
+	return runtime.New(name, zemmPWD)
+}
+
+func printStatus(status []compose.PackageStatus, unmanaged []runtime.Container) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tVERSION\tPROVIDES\tLIST\tSERVICE\tCONTAINER\tSTATE\tSTATUS")
+	for _, ps := range status {
+		provides := "-"
+		if len(ps.Provides) > 0 {
+			provides = strings.Join(ps.Provides, ",")
+		}
+		if len(ps.Services) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t-\t-\t-\t-\n", ps.Package, ps.Version, provides, ps.List)
+			continue
+		}
+
+		first := true
+		for _, s := range ps.Services {
+			containers := s.Containers
+			if len(containers) == 0 {
+				containers = []runtime.Container{{Name: "-", State: "not created", Status: "-"}}
+			}
+			for _, c := range containers {
+				if first {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t", ps.Package, ps.Version, provides, ps.List)
+					first = false
+				} else {
+					fmt.Fprint(w, "\t\t\t\t")
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Service, c.Name, c.State, c.Status)
+			}
+		}
+	}
+	for _, c := range unmanaged {
+		fmt.Fprintf(w, "-\t-\t-\t-\t%s\t%s\t%s\t%s\n", c.Service, c.Name, c.State, c.Status)
+	}
+	w.Flush()
+}
+
+func newComposeCommand() *cobra.Command {
+	var runtimeName string
+	cmd := &cobra.Command{
+		Use:   "compose",
+		Short: "Generate the docker-compose.yaml of the installed packages and run it",
+	}
+	cmd.PersistentFlags().StringVar(&runtimeName, "runtime", "", fmt.Sprintf("Container runtime to use, one of %v (default $ZEMM_RUNTIME or compose)", runtime.Names))
+
+	var dryRun bool
+	generateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Merge the compose fragments of all installed packages into docker-compose.yaml",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return generateCompose(dryRun)
+		},
+	}
+	generateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the result instead of writing it")
+
+	var upDryRun, detach bool
+	upCmd := &cobra.Command{
+		Use:   "up",
+		Short: "Create the docker-compose.yaml and start the services",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := generateCompose(upDryRun); err != nil || upDryRun {
+				return err
+			}
+
+			rt, err := newRuntime(runtimeName)
+			if err != nil {
+				return err
+			}
+			if err := rt.Up(); err != nil || detach {
+				return err
+			}
+			return rt.Logs(os.Stdout, true)
+		},
+	}
+	upCmd.Flags().BoolVar(&upDryRun, "dry-run", false, "Print the docker-compose.yaml instead of writing and running it")
+	upCmd.Flags().BoolVarP(&detach, "detach", "d", false, "Run containers in the background")
+
+	downCmd := &cobra.Command{
+		Use:   "down [package]",
+		Short: "Stop and remove the services, apps first then their dependencies",
+		Long: `Stop and remove the services in reverse dependency order, the app first,
+then the dependencies of the app, then the dependencies of the dependencies.
+
+With a package only that package and the dependencies no other installed
+package needs are torn down.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := project.Load(zemmPWD)
+			if err != nil {
+				return err
+			}
+			lock, err := install.ReadLock(p.Dir())
+			if err != nil {
+				return err
+			}
+
+			only := ""
+			if len(args) > 0 {
+				only = args[0]
+			}
+
+			order, err := compose.DownOrder(lock, p.InstallNames(), only)
+			if err != nil {
+				return err
+			}
+
+			g, err := compose.NewProjectGenerator(p, lock, false)
+			if err != nil {
+				return err
+			}
+
+			rt, err := newRuntime(runtimeName)
+			if err != nil {
+				return err
+			}
+
+			services, err := compose.Down(rt, g, order)
+			for _, s := range services {
+				fmt.Printf("Removed: %s\n", s)
+			}
+			return err
+		},
+	}
+
+	var follow bool
+	logsCmd := &cobra.Command{
+		Use:   "logs [service...]",
+		Short: "Print the logs of the services",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rt, err := newRuntime(runtimeName)
+			if err != nil {
+				return err
+			}
+			return rt.Logs(os.Stdout, follow, args...)
+		},
+	}
+	logsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow the log output")
+
+	pullCmd := &cobra.Command{
+		Use:   "pull [service...]",
+		Short: "Pull the images of the services",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rt, err := newRuntime(runtimeName)
+			if err != nil {
+				return err
+			}
+			return rt.Pull(args...)
+		},
+	}
+
+	var jsonOutput bool
+	psCmd := &cobra.Command{
+		Use:   "ps",
+		Short: "Show the state of the services grouped by installed package",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := project.Load(zemmPWD)
+			if err != nil {
+				return err
+			}
+			lock, err := install.ReadLock(p.Dir())
+			if err != nil {
+				return err
+			}
+			g, err := compose.NewProjectGenerator(p, lock, true)
+			if err != nil {
+				return err
+			}
+			rt, err := newRuntime(runtimeName)
+			if err != nil {
+				return err
+			}
+
+			status, unmanaged, err := compose.Status(g, lock, rt)
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				data, err := json.MarshalIndent(map[string]interface{}{
+					"packages":  status,
+					"unmanaged": unmanaged,
+				}, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			printStatus(status, unmanaged)
+			return nil
+		},
+	}
+	psCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the status as JSON")
+
+	cmd.AddCommand(generateCmd)
+	cmd.AddCommand(upCmd)
+	cmd.AddCommand(downCmd)
+	cmd.AddCommand(logsCmd)
+	cmd.AddCommand(pullCmd)
+	cmd.AddCommand(psCmd)
+	return cmd
+}
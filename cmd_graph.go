@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zemm-io/zemm/pm"
+)
+
+func newGraphCommand() *cobra.Command {
+	var (
+		format       string
+		collapse     bool
+		noRecommends bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "graph",
+		Short: "Print the resolved dependency graph as DOT, Mermaid or JSON",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mgr, err := resolveProject(!noRecommends)
+			if err != nil {
+				return err
+			}
+
+			g := mgr.ResolvedGraph()
+			if collapse {
+				g = g.Collapse()
+			}
+
+			switch format {
+			case "dot":
+				fmt.Print(g.DOT())
+			case "mermaid":
+				fmt.Print(g.Mermaid())
+			case "json":
+				data, err := json.MarshalIndent(g, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+			default:
+				return fmt.Errorf("Unknown format \"%s\", use one of %s", format, strings.Join(pm.GraphFormats, ", "))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&format, "format", "f", "dot", "Output format: dot, mermaid or json")
+	cmd.Flags().BoolVar(&collapse, "collapse", false, "Collapse the packages of a namespace into one node")
+	cmd.Flags().BoolVar(&noRecommends, "no-recommends", false, "Resolve without recommended packages")
+	return cmd
+}
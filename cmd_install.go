@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/project"
+)
+
+func printWarnings(warnings []error) {
+	for _, w := range warnings {
+		fmt.Printf("WARN: %v\n", w)
+	}
+}
+
+func printChanges(result *install.Result) {
+	recommended := make(map[string]bool)
+	for _, name := range result.Recommended {
+		recommended[name] = true
+	}
+
+	for _, c := range result.Added {
+		if recommended[c.Name] {
+			fmt.Printf("Added:      %s (%s, recommended)\n", c.Name, c.NewVersion)
+			continue
+		}
+		fmt.Printf("Added:      %s (%s)\n", c.Name, c.NewVersion)
+	}
+	for _, c := range result.Upgraded {
+		fmt.Printf("Upgraded:   %s (%s -> %s)\n", c.Name, c.OldVersion, c.NewVersion)
+	}
+	for _, c := range result.Downgraded {
+		fmt.Printf("Downgraded: %s (%s -> %s)\n", c.Name, c.OldVersion, c.NewVersion)
+	}
+	for _, c := range result.Removed {
+		fmt.Printf("Removed:    %s (%s)\n", c.Name, c.OldVersion)
+	}
+	for _, d := range result.Preferred {
+		fmt.Printf("Preferred:  %s for %s (\"%s\")\n", d.Package, d.Dependency, d.Preference)
+	}
+	for _, o := range result.Overlaid {
+		how := "replaced"
+		if o.Patched {
+			how = "patched"
+		}
+		fmt.Printf("Overlaid:   %s:%s by %s (%s)\n", o.Package, o.File, o.By, how)
+	}
+
+	fmt.Printf("%d packages installed, %d added, %d upgraded, %d downgraded, %d removed\n",
+		len(result.Packages), len(result.Added), len(result.Upgraded), len(result.Downgraded), len(result.Removed))
+}
+
+func newInstallCommand() *cobra.Command {
+	var noRecommends bool
+
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Download all lists, resolve the packages to install and download them",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := project.Load(zemmPWD)
+			if err != nil {
+				return err
+			}
+
+			i := install.NewInstaller(p)
+			i.Recommends = !noRecommends
+			i.Events = newProgress(os.Stderr)
+
+			result, err := runChoosingProviders(i)
+			if err != nil {
+				return err
+			}
+
+			printWarnings(result.Warnings)
+			printChanges(result)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&noRecommends, "no-recommends", false, "Don't install recommended packages unless \"recommends\" is set for the package")
+	return cmd
+}
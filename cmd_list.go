@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/pm"
+)
+
+func newListCommand() *cobra.Command {
+	var (
+		jsonOutput, explicit bool
+		typ                  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the installed packages",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			known := typ == ""
+			for _, t := range pm.PackageTypes {
+				known = known || t == typ
+			}
+			if !known {
+				return fmt.Errorf("Unknown type \"%s\", use one of %s", typ, strings.Join(pm.PackageTypes, ", "))
+			}
+
+			state, err := install.ReadState(zemmPWD)
+			if err != nil {
+				return err
+			}
+
+			pkgs := []install.InstalledPackage{}
+			for _, p := range state.Packages {
+				if typ != "" && p.Type != typ {
+					continue
+				}
+				if !explicit || p.Reason == install.ReasonExplicit {
+					pkgs = append(pkgs, p)
+				}
+			}
+
+			if jsonOutput {
+				data, err := json.MarshalIndent(pkgs, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PACKAGE\tVERSION\tTYPE\tREASON\tLIST\tFILES")
+			for _, p := range pkgs {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", p.Name, p.Version, p.Type, p.Reason, p.List, len(p.Files))
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the packages as JSON")
+	cmd.Flags().BoolVar(&explicit, "explicit", false, "Only list explicitly installed packages")
+	cmd.Flags().StringVar(&typ, "type", "", "Only list packages of the type app, service, library or plugin")
+	return cmd
+}
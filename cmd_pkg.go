@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/pkg"
+)
+
+func newPkgCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pkg",
+		Short: "Work with the package in the current directory",
+	}
+
+	var outDir string
+	buildCmd := &cobra.Command{
+		Use:   "build [zemmpkg.yaml]",
+		Short: "Verify the package and build its archive",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file := path.Join(zemmPWD, pkg.FileName)
+			if len(args) > 0 {
+				file = args[0]
+			}
+			// Relative paths are relative to the zemm working directory
+			if !path.IsAbs(file) {
+				file = path.Join(zemmPWD, file)
+			}
+			out := outDir
+			if !path.IsAbs(out) {
+				out = path.Join(zemmPWD, out)
+			}
+
+			p, err := pkg.NewPkg(file)
+			if err != nil {
+				return err
+			}
+			if err := p.Verify(); err != nil {
+				return err
+			}
+
+			archive, err := p.MakePackage(out)
+			if err != nil {
+				return err
+			}
+
+			fi, err := os.Stat(archive)
+			if err != nil {
+				return err
+			}
+			digest, err := common.FileSHA256(archive)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Built %s %s\n", p.Info.Name, p.Info.Version)
+			fmt.Printf("Archive: %s\n", archive)
+			fmt.Printf("Size:    %s (%d bytes)\n", formatBytes(fi.Size()), fi.Size())
+			fmt.Printf("SHA256:  %s\n", digest)
+			return nil
+		},
+	}
+	buildCmd.Flags().StringVarP(&outDir, "output", "o", ".", "Directory to write the archive to, relative to the working directory of zemm")
+
+	cmd.AddCommand(buildCmd)
+	return cmd
+}
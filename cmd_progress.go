@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/zemm-io/zemm/events"
+)
+
+const progressWidth = 30
+
+// isTerminal reports if f is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func formatBytes(b int64) string {
+	switch {
+	case b >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(b)/(1<<20))
+	case b >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(b)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", b)
+}
+
+// newProgress returns a sink drawing the lists fetched and a progress bar
+// per package download on w, nothing gets drawn if w is not a terminal
+func newProgress(w *os.File) events.Sink {
+	if !isTerminal(w) {
+		return nil
+	}
+
+	return func(e events.Event) {
+		drawProgress(w, e)
+	}
+}
+
+func drawProgress(w io.Writer, e events.Event) {
+	switch e.Type {
+	case events.ListFetched:
+		fmt.Fprintf(w, "Fetched:    %s\n", e.List)
+	case events.DownloadProgress:
+		bar := strings.Repeat(" ", progressWidth)
+		size := formatBytes(e.Bytes)
+		if e.Total > 0 {
+			done := int(int64(progressWidth) * e.Bytes / e.Total)
+			bar = strings.Repeat("#", done) + strings.Repeat("-", progressWidth-done)
+			size += "/" + formatBytes(e.Total)
+		}
+		fmt.Fprintf(w, "\r%s (%s) [%s] %s", e.Package, e.Version, bar, size)
+	case events.ExtractDone:
+		fmt.Fprintf(w, "\r\033[KExtracted:  %s (%s)\n", e.Package, e.Version)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/pm"
+	"github.com/zemm-io/zemm/project"
+)
+
+// askChoice asks to choose one of choices by number
+func askChoice(reader *bufio.Reader, question string, choices []string) (string, error) {
+	for {
+		fmt.Println(question)
+		for n, c := range choices {
+			fmt.Printf("  %d) %s\n", n+1, c)
+		}
+		fmt.Printf("Choice [1-%d]: ", len(choices))
+
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("No choice made")
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(answer))
+		if err == nil && n >= 1 && n <= len(choices) {
+			return choices[n-1], nil
+		}
+		fmt.Printf("Invalid choice \"%s\"\n", strings.TrimSpace(answer))
+	}
+}
+
+// chooseProviders asks for a provider of every virtual package in err that
+// has several and writes the choices to the zemm.yaml, it returns false if
+// there was nothing to choose
+func chooseProviders(p *project.Project, err error) (bool, error) {
+	choices := pm.ProviderChoices(err)
+	if len(choices) == 0 {
+		return false, nil
+	}
+	if !isTerminal(os.Stdin) {
+		return false, fmt.Errorf("%v\nChoose them in \"providers\" of %s", err, project.FileName)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	for _, c := range choices {
+		if _, ok := p.Providers[c.Package]; ok {
+			continue
+		}
+
+		provider, err := askChoice(reader, fmt.Sprintf("%s depends on %s, which provider should be installed?", c.By, c.Package), c.Candidates)
+		if err != nil {
+			return false, err
+		}
+		if err := p.SetProvider(c.Package, provider); err != nil {
+			return false, err
+		}
+		fmt.Printf("Chose %s as provider of %s in %s\n", provider, c.Package, project.FileName)
+	}
+
+	return true, nil
+}
+
+// runChoosingProviders runs i until all providers have been chosen
+func runChoosingProviders(i *install.Installer) (*install.Result, error) {
+	for {
+		result, err := i.Run()
+		if err == nil {
+			return result, nil
+		}
+
+		chose, cErr := chooseProviders(i.Project, err)
+		if cErr != nil {
+			return nil, cErr
+		}
+		if !chose {
+			return nil, err
+		}
+	}
+}
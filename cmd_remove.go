@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/project"
+)
+
+// removePackages removes names, or the orphaned dependencies without names,
+// and prints what has been removed
+func removePackages(names []string, dryRun bool) error {
+	p, err := project.Load(zemmPWD)
+	if err != nil {
+		return err
+	}
+
+	result, err := install.NewInstaller(p).Remove(names, dryRun)
+	if err != nil {
+		return err
+	}
+
+	printWarnings(result.Warnings)
+	prefix := "Removed:   "
+	if dryRun {
+		prefix = "Would remove:"
+	}
+	for _, c := range result.Removed {
+		fmt.Printf("%s %s (%s)\n", prefix, c.Name, c.OldVersion)
+	}
+	if len(result.Removed) == 0 {
+		fmt.Println("Nothing to remove")
+	}
+
+	return nil
+}
+
+func newRemoveCommand() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "remove <package>...",
+		Short: "Remove packages and the dependencies nothing else needs",
+		Long: `Remove the packages from "install" and uninstall them together with their
+dependencies no other explicitly installed package needs. Packages other
+packages still need stay installed as dependencies.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return removePackages(args, dryRun)
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only list what would be removed")
+	return cmd
+}
+
+func newAutoremoveCommand() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "autoremove",
+		Short: "Remove installed dependencies no explicitly installed package needs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return removePackages(nil, dryRun)
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only list what would be removed")
+	return cmd
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/zemm-io/zemm/install"
+)
+
+func newRollbackCommand() *cobra.Command {
+	var list bool
+
+	cmd := &cobra.Command{
+		Use:   "rollback [generation]",
+		Short: "Switch back to the previous or the given install generation",
+		Long: `Every install that changes something creates a new generation, rollback
+switches back to the generation before the current one or to the given one
+and restores the zemm files and the lockfile it was built from.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if list {
+				generations, err := install.Generations(zemmPWD)
+				if err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "GENERATION\tCREATED\tPACKAGES\tCURRENT")
+				for _, g := range generations {
+					current := ""
+					if g.Current {
+						current = "*"
+					}
+					fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", g.Number, g.Created.Format("2006-01-02 15:04:05"), g.Packages, current)
+				}
+				return w.Flush()
+			}
+
+			n := 0
+			if len(args) > 0 {
+				var err error
+				if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+					return fmt.Errorf("Invalid generation \"%s\"", args[0])
+				}
+			}
+
+			g, err := install.Rollback(zemmPWD, n)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Rolled back to generation %d from %s\n", g.Number, g.Created.Format("2006-01-02 15:04:05"))
+			fmt.Println("Run \"zemm compose up -d\" to apply it to the services")
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&list, "list", "l", false, "List the generations")
+	return cmd
+}
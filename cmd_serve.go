@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/server"
+)
+
+func newServeCommand() *cobra.Command {
+	var listen, runtimeName, authFile, auditLog, tlsCert, tlsKey, clientCA string
+	var allowlist, insecure bool
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the daemon with the HTTP API to install packages at runtime",
+		Long: `Run the daemon with the HTTP/JSON API other services use to list, resolve,
+install, remove and upgrade packages of the project in the current directory.
+
+Install, remove and upgrade return a job, poll /v1/jobs/<id> for its result.
+
+The credentials are read from --auth, a YAML file with tokens and TLS
+client certificate names and their scopes (read, install, remove, admin):
+
+  tokens:
+    - name: shop
+      token: "a long random secret"
+      scopes: [read, install]
+  clients:
+    - name: admin.example.com
+      scopes: [admin]`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rt, err := newRuntime(runtimeName)
+			if err != nil {
+				return err
+			}
+
+			s := server.New(zemmPWD, rt)
+			s.Allowlist = allowlist
+
+			if authFile == "" && !insecure {
+				return fmt.Errorf("No --auth given, use --insecure to run the API without authentication")
+			}
+			if authFile != "" {
+				if s.Auth, err = server.LoadAuth(authFile); err != nil {
+					return err
+				}
+			}
+
+			if auditLog == "" {
+				auditLog = path.Join(zemmPWD, install.StateDir, "audit.log")
+			}
+			if err := os.MkdirAll(path.Dir(auditLog), os.ModeDir|(common.OS_USER_RWX|common.OS_GROUP_RX|common.OS_OTH_RX)); err != nil {
+				return err
+			}
+			audit, err := os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, common.OS_USER_RW)
+			if err != nil {
+				return err
+			}
+			defer audit.Close()
+			s.Audit = audit
+
+			srv := &http.Server{Addr: listen, Handler: s.Handler()}
+			if tlsCert == "" {
+				if clientCA != "" {
+					return fmt.Errorf("--client-ca requires --tls-cert and --tls-key")
+				}
+				fmt.Printf("Listening on %s\n", listen)
+				return srv.ListenAndServe()
+			}
+
+			if clientCA != "" {
+				pem, err := ioutil.ReadFile(clientCA)
+				if err != nil {
+					return err
+				}
+				pool := x509.NewCertPool()
+				if !pool.AppendCertsFromPEM(pem) {
+					return fmt.Errorf("No certificates found in %s", clientCA)
+				}
+				srv.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+			}
+
+			fmt.Printf("Listening on %s (TLS)\n", listen)
+			return srv.ListenAndServeTLS(tlsCert, tlsKey)
+		},
+	}
+	cmd.Flags().StringVar(&listen, "listen", ":8080", "Address to listen on")
+	cmd.Flags().StringVar(&runtimeName, "runtime", "", "Container runtime to use (default $ZEMM_RUNTIME or compose)")
+	cmd.Flags().StringVar(&authFile, "auth", "", "YAML file with the tokens and client certificates allowed to use the API")
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Run without authentication")
+	cmd.Flags().BoolVar(&allowlist, "allowlist", false, "Only allow installing packages the main list supports")
+	cmd.Flags().StringVar(&auditLog, "audit-log", "", "File the mutating calls get logged to (default .zemm/audit.log)")
+	cmd.Flags().StringVar(&tlsCert, "tls-cert", "", "TLS certificate file")
+	cmd.Flags().StringVar(&tlsKey, "tls-key", "", "TLS key file")
+	cmd.Flags().StringVar(&clientCA, "client-ca", "", "CA file to verify TLS client certificates with")
+
+	return cmd
+}
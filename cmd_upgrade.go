@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/project"
+)
+
+func printChangeTable(result *install.Result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tOLD\tNEW\tCHANGE")
+	for _, c := range result.Added {
+		fmt.Fprintf(w, "%s\t-\t%s\tadd\n", c.Name, c.NewVersion)
+	}
+	for _, c := range result.Upgraded {
+		fmt.Fprintf(w, "%s\t%s\t%s\tupgrade\n", c.Name, c.OldVersion, c.NewVersion)
+	}
+	for _, c := range result.Downgraded {
+		fmt.Fprintf(w, "%s\t%s\t%s\tdowngrade\n", c.Name, c.OldVersion, c.NewVersion)
+	}
+	for _, c := range result.Removed {
+		fmt.Fprintf(w, "%s\t%s\t-\tremove\n", c.Name, c.OldVersion)
+	}
+	w.Flush()
+}
+
+func askConfirmation(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func newUpdateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Download the newest lists and packages of the same version",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := project.Load(zemmPWD)
+			if err != nil {
+				return err
+			}
+
+			i := install.NewInstaller(p)
+			i.Refresh = true
+			i.Events = newProgress(os.Stderr)
+
+			result, err := runChoosingProviders(i)
+			if err != nil {
+				return err
+			}
+
+			printWarnings(result.Warnings)
+			for _, c := range result.Updated {
+				fmt.Printf("Updated:    %s (%s)\n", c.Name, c.NewVersion)
+			}
+			printChanges(result)
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func newUpgradeCommand() *cobra.Command {
+	var quiet, yes bool
+
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Upgrade to newer versions of the lists after confirmation",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Without the plan there is nothing to confirm
+			if quiet && !yes {
+				return fmt.Errorf("--quiet needs --yes")
+			}
+
+			p, err := project.Load(zemmPWD)
+			if err != nil {
+				return err
+			}
+
+			i := install.NewInstaller(p)
+
+			upgrades, err := i.ListUpgrades()
+			if err != nil {
+				return err
+			}
+			if len(upgrades) == 0 {
+				if !quiet {
+					fmt.Println("All lists are up to date")
+				}
+				return nil
+			}
+
+			plan, err := i.PlanUpgrade(upgrades)
+			if err != nil {
+				return err
+			}
+
+			if !quiet {
+				printWarnings(plan.Warnings)
+				for _, u := range upgrades {
+					fmt.Printf("List: %s -> %s\n", u.From, u.To)
+				}
+				fmt.Println()
+				printChangeTable(plan)
+				fmt.Println()
+			}
+
+			if !yes && !askConfirmation("Do you want to upgrade?") {
+				return fmt.Errorf("Upgrade aborted")
+			}
+
+			if !quiet {
+				i.Events = newProgress(os.Stderr)
+			}
+			result, err := i.Upgrade(upgrades)
+			if err != nil {
+				return err
+			}
+
+			if !quiet {
+				printChanges(result)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Don't print anything except errors, needs --yes")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Don't ask for confirmation")
+	return cmd
+}
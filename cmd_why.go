@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/pm"
+	"github.com/zemm-io/zemm/project"
+)
+
+// resolveProject resolves the packages of the project and returns the
+// package manager holding the decisions, it is also returned if only the
+// resolution failed to explain the failure
+func resolveProject(recommends bool) (*pm.PackageManager, error) {
+	p, err := project.Load(zemmPWD)
+	if err != nil {
+		return nil, err
+	}
+
+	i := install.NewInstaller(p)
+	i.Recommends = recommends
+
+	mgr, err := i.NewPackageManager()
+	if err != nil {
+		return nil, err
+	}
+	_, _, err = i.Resolve(mgr)
+	return mgr, err
+}
+
+func newWhyCommand() *cobra.Command {
+	var noRecommends bool
+
+	cmd := &cobra.Command{
+		Use:   "why <package>",
+		Short: "Show the dependency path from an explicit install to a package",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mgr, err := resolveProject(!noRecommends)
+			if err != nil {
+				return err
+			}
+
+			path, err := mgr.Why(args[0])
+			if err != nil {
+				return fmt.Errorf("%v, see \"zemm why-not %s\"", err, args[0])
+			}
+
+			for i, d := range path {
+				fmt.Printf("%*s%s\n", i*2, "", d)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&noRecommends, "no-recommends", false, "Resolve without recommended packages")
+	return cmd
+}
+
+func newWhyNotCommand() *cobra.Command {
+	var noRecommends bool
+
+	cmd := &cobra.Command{
+		Use:   "why-not <package>",
+		Short: "Show why a package has not been selected",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// A failed resolution still holds the decisions that made it fail
+			mgr, resolveErr := resolveProject(!noRecommends)
+			if mgr == nil {
+				return resolveErr
+			}
+
+			decisions, err := mgr.WhyNot(args[0])
+			if err != nil {
+				if resolveErr != nil {
+					return resolveErr
+				}
+				return fmt.Errorf("%v, see \"zemm why %s\"", err, args[0])
+			}
+
+			if len(decisions) == 0 {
+				fmt.Printf("Nothing depends on or recommends %s\n", args[0])
+			}
+			for _, d := range decisions {
+				fmt.Println(d)
+			}
+			return resolveErr
+		},
+	}
+
+	cmd.Flags().BoolVar(&noRecommends, "no-recommends", false, "Resolve without recommended packages")
+	return cmd
+}
@@ -1,6 +1,8 @@
 package common
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,7 +11,11 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 
+	"github.com/hashicorp/go-multierror"
+	"github.com/tpazderka/warning"
 	"gopkg.in/yaml.v2"
 )
 
@@ -122,6 +128,10 @@ func DownloadURLToByte(url string) ([]byte, error) {
 		defer res.Body.Close()
 	}
 
+	if res.StatusCode != http.StatusOK {
+		return []byte{}, fmt.Errorf("Failed to download %v, status was: %s", url, res.Status)
+	}
+
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return []byte{}, fmt.Errorf("Failed to download %v, error was: %s", url, err)
@@ -130,6 +140,75 @@ func DownloadURLToByte(url string) ([]byte, error) {
 	return body, nil
 }
 
+// DownloadURLToFile downloads url (or copies it when its a local path) to destination
+func DownloadURLToFile(url string, destination string) error {
+	return DownloadURLToFileWithProgress(url, destination, nil)
+}
+
+// progressWriter reports the number of bytes written so far
+type progressWriter struct {
+	done     int64
+	total    int64
+	progress func(done, total int64)
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.done += int64(len(b))
+	w.progress(w.done, w.total)
+	return len(b), nil
+}
+
+// DownloadURLToFileWithProgress is DownloadURLToFile calling progress with the bytes
+// downloaded so far and the total size (-1 if unknown), progress may be nil
+func DownloadURLToFileWithProgress(url string, destination string, progress func(done, total int64)) error {
+	if !URLIsValidAndHTTP(url) {
+		if err := CopyFile(url, destination, true); err != nil {
+			return err
+		}
+		if progress != nil {
+			if fi, err := os.Stat(destination); err == nil {
+				progress(fi.Size(), fi.Size())
+			}
+		}
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to download %v, error was: %s", url, err)
+	}
+	req.Header.Set("User-Agent", "zemmaschaffa-go")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to download %v, error was: %s", url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to download %v, status was: %s", url, res.Status)
+	}
+
+	if err := os.MkdirAll(path.Dir(destination), os.ModeDir|(OS_USER_RWX|OS_GROUP_RX|OS_OTH_RX)); err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(destination, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, OS_USER_RW|OS_GROUP_R|OS_OTH_R)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	var body io.Reader = res.Body
+	if progress != nil {
+		body = io.TeeReader(res.Body, &progressWriter{total: res.ContentLength, progress: progress})
+	}
+	if _, err := io.Copy(fp, body); err != nil {
+		return fmt.Errorf("Failed to download %v, error was: %s", url, err)
+	}
+
+	return nil
+}
+
 // URLToStruct reads a URL/File and parses it into the interface out
 func URLToStruct(url string, out interface{}) (err error) {
 	var contents []byte
@@ -229,3 +308,130 @@ func CopyFile(src string, destination string, makedirs bool) error {
 
 	return err
 }
+
+// FileSHA256 returns the hex encoded sha256 digest of the given file
+func FileSHA256(filename string) (string, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fp); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SplitWarnings separates warnings from real errors in err
+func SplitWarnings(err error) ([]error, error) {
+	warnings := []error{}
+	if err == nil {
+		return warnings, nil
+	}
+
+	result := &multierror.Error{}
+
+	switch v := err.(type) {
+	case *multierror.Error:
+		for _, merr := range v.WrappedErrors() {
+			if warning.IsWarning(merr) {
+				warnings = append(warnings, merr)
+			} else {
+				result = multierror.Append(result, merr)
+			}
+		}
+	default:
+		if warning.IsWarning(err) {
+			warnings = append(warnings, err)
+		} else {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return warnings, result.ErrorOrNil()
+}
+
+// MatchVersion reports if version matches constraint, a comma separated list
+// of comparisons like ">=2,<3", a version without operator must be equal
+func MatchVersion(constraint, version string) (bool, error) {
+	if strings.TrimSpace(constraint) == "" {
+		return true, nil
+	}
+
+	result := true
+	for _, c := range strings.Split(constraint, ",") {
+		c = strings.TrimSpace(c)
+
+		op := "="
+		for _, o := range []string{">=", "<=", "!=", "==", ">", "<", "="} {
+			if strings.HasPrefix(c, o) {
+				op = o
+				break
+			}
+		}
+		want := strings.TrimSpace(strings.TrimPrefix(c, op))
+		if want == "" || strings.ContainsAny(want, "<>=!") {
+			return false, fmt.Errorf("Invalid version constraint \"%s\"", constraint)
+		}
+		if version == "" {
+			result = false
+			continue
+		}
+
+		cmp := CompareVersions(version, want)
+		switch op {
+		case ">=":
+			result = result && cmp >= 0
+		case "<=":
+			result = result && cmp <= 0
+		case ">":
+			result = result && cmp > 0
+		case "<":
+			result = result && cmp < 0
+		case "!=":
+			result = result && cmp != 0
+		default:
+			result = result && cmp == 0
+		}
+	}
+
+	return result, nil
+}
+
+// CompareVersions compares two dotted versions like "1.0.10" and "1.0.9",
+// it returns -1 if a < b, 0 if they are equal and 1 if a > b
+func CompareVersions(a, b string) int {
+	ap := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bp := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(ap) || i < len(bp); i++ {
+		as, bs := "0", "0"
+		if i < len(ap) {
+			as = ap[i]
+		}
+		if i < len(bp) {
+			bs = bp[i]
+		}
+
+		an, aErr := strconv.Atoi(as)
+		bn, bErr := strconv.Atoi(bs)
+		if aErr == nil && bErr == nil {
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+
+		if c := strings.Compare(as, bs); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
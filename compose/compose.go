@@ -0,0 +1,261 @@
+package compose
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"sort"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/project"
+	"github.com/zemm-io/zemm/subst"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FragmentPath is the location of the compose fragment inside a package
+	FragmentPath = "zemmpkg/compose.yaml"
+	// FileName is the name of the generated compose file in the project directory
+	FileName = "docker-compose.yaml"
+)
+
+// Sections are the top level keys a fragment may define, in output order
+var Sections = []string{"services", "networks", "volumes", "configs", "secrets"}
+
+type entry struct {
+	pkg   string
+	value interface{}
+}
+
+type Generator struct {
+	sections map[string]map[string]entry
+	// packages maps package and provider names to the resolved package
+	packages   map[string]string
+	injections map[string]Injection
+}
+
+func NewGenerator() *Generator {
+	g := &Generator{
+		sections:   make(map[string]map[string]entry),
+		packages:   make(map[string]string),
+		injections: make(map[string]Injection),
+	}
+	for _, s := range Sections {
+		g.sections[s] = make(map[string]entry)
+	}
+
+	return g
+}
+
+func isSection(key string) bool {
+	for _, s := range Sections {
+		if s == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddFragment merges the compose fragment data of package pkg
+func (g *Generator) AddFragment(pkg string, data []byte) error {
+	result := &multierror.Error{}
+
+	doc := yaml.MapSlice{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("Failed to decode the compose fragment of package \"%s\", error was: %s", pkg, err)
+	}
+
+	for _, item := range doc {
+		key := fmt.Sprintf("%v", item.Key)
+		if key == "version" {
+			// The generated file uses the compose specification
+			continue
+		}
+		if !isSection(key) {
+			result = multierror.Append(result, fmt.Errorf("Package \"%s\": unsupported compose key \"%s\"", pkg, key))
+			continue
+		}
+
+		values, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			if item.Value != nil {
+				result = multierror.Append(result, fmt.Errorf("Package \"%s\": \"%s\" must be a map", pkg, key))
+			}
+			continue
+		}
+
+		for _, v := range values {
+			name := fmt.Sprintf("%v", v.Key)
+			if known, ok := g.sections[key][name]; ok {
+				// Services must be unique, networks, volumes, ... may be shared when they are equal
+				if key == "services" || !reflect.DeepEqual(known.value, v.Value) {
+					result = multierror.Append(result, fmt.Errorf("Package \"%s\": %s \"%s\" is already defined by package \"%s\"", pkg, key, name, known.pkg))
+				}
+				continue
+			}
+			g.sections[key][name] = entry{pkg: pkg, value: v.Value}
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// AddFragmentFile merges the compose fragment in file
+func (g *Generator) AddFragmentFile(pkg, file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	return g.AddFragment(pkg, data)
+}
+
+// serviceNetworks returns the networks referenced by the service definition s
+func serviceNetworks(s interface{}) []string {
+	result := []string{}
+
+	service, ok := s.(yaml.MapSlice)
+	if !ok {
+		return result
+	}
+
+	for _, item := range service {
+		if item.Key != "networks" {
+			continue
+		}
+		switch v := item.Value.(type) {
+		case yaml.MapSlice:
+			for _, n := range v {
+				result = append(result, fmt.Sprintf("%v", n.Key))
+			}
+		case []interface{}:
+			for _, n := range v {
+				result = append(result, fmt.Sprintf("%v", n))
+			}
+		}
+	}
+
+	return result
+}
+
+// Document returns the merged compose document with sorted keys
+func (g *Generator) Document() (yaml.MapSlice, error) {
+	result := &multierror.Error{}
+
+	// Declare networks the services use but no package defines
+	for _, s := range g.sections["services"] {
+		for _, n := range serviceNetworks(s.value) {
+			if _, ok := g.sections["networks"][n]; !ok {
+				g.sections["networks"][n] = entry{pkg: s.pkg, value: yaml.MapSlice{}}
+			}
+		}
+	}
+
+	doc := yaml.MapSlice{}
+	for _, s := range Sections {
+		if len(g.sections[s]) == 0 {
+			continue
+		}
+
+		names := make([]string, 0, len(g.sections[s]))
+		for n := range g.sections[s] {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		values := yaml.MapSlice{}
+		for _, n := range names {
+			value := g.sections[s][n].value
+			if s == "services" {
+				var err error
+				if value, err = g.translateService(n, g.sections[s][n]); err != nil {
+					result = multierror.Append(result, err)
+				}
+				if inj, ok := g.injections[g.sections[s][n].pkg]; ok {
+					value = injectService(value, inj)
+				}
+			}
+			values = append(values, yaml.MapItem{Key: n, Value: value})
+		}
+		doc = append(doc, yaml.MapItem{Key: s, Value: values})
+	}
+
+	return doc, result.ErrorOrNil()
+}
+
+// Marshal returns the merged compose document as yaml
+func (g *Generator) Marshal() ([]byte, error) {
+	doc, err := g.Document()
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(doc)
+}
+
+// PackageVars returns the variables available in the compose fragment of the locked package lp,
+// declared are the values of the settings the package declares
+func PackageVars(p *project.Project, lp install.LockPackage, declared map[string]string) subst.Vars {
+	settings := subst.Vars(p.Settings.Vars(lp.Name))
+	return settings.Merge(declared, subst.BuiltinVars(lp.Name, lp.Version, install.PackageDir(p.Dir(), lp.Name)))
+}
+
+// NewProjectGenerator creates a generator with the compose fragments of all
+// packages in lock, with mask the values of secret settings get replaced by SecretMask
+func NewProjectGenerator(p *project.Project, lock *install.Lock, mask bool) (*Generator, error) {
+	result := &multierror.Error{}
+	g := NewGenerator()
+
+	for _, lp := range lock.Packages {
+		g.AddPackage(lp.Name, lp.Provides)
+	}
+
+	for _, lp := range lock.Packages {
+		inj, declared, err := PackageSettings(p, lp, mask)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		g.Inject(lp.Name, inj)
+
+		f := path.Join(install.PackageDir(p.Dir(), lp.Name), FragmentPath)
+		if !common.FileExists(f) {
+			continue
+		}
+
+		data, err := subst.File(f, PackageVars(p, lp, declared))
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("Package \"%s\": %v", lp.Name, err))
+			continue
+		}
+		if err := g.AddFragment(lp.Name, data); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return g, result.ErrorOrNil()
+}
+
+// Generate merges the compose fragments of all packages in lock
+func Generate(p *project.Project, lock *install.Lock, mask bool) ([]byte, error) {
+	g, err := NewProjectGenerator(p, lock, mask)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.Marshal()
+}
+
+// Write generates the compose file of the project
+func Write(p *project.Project, lock *install.Lock) error {
+	data, err := Generate(p, lock, false)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(p.Dir(), FileName), data, common.OS_USER_RW|common.OS_GROUP_R|common.OS_OTH_R)
+}
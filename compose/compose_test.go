@@ -0,0 +1,297 @@
+package compose
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/pkg"
+	"github.com/zemm-io/zemm/project"
+	"github.com/zemm-io/zemm/runtime"
+)
+
+// newTestProject creates a project with settings whose current generation
+// has the given files of package library/nats
+func newTestProject(t *testing.T, settings string, files map[string]string) (*project.Project, *install.Lock) {
+	dir, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := "version: 1.0\nlists:\n  main: library/nats/2.1.9\ninstall: []\n" + settings
+	if err := ioutil.WriteFile(path.Join(dir, project.FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range files {
+		f := path.Join(install.PackageDir(dir, "library/nats"), name)
+		if err := os.MkdirAll(path.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(f, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, &install.Lock{Packages: []install.LockPackage{{Name: "library/nats", Version: "2.1.9"}}}
+}
+
+func TestMergeFragments(t *testing.T) {
+	g := NewGenerator()
+
+	err := g.AddFragmentFile("library/nats", "../examples/apps/library/nats/zemmpkg/compose.yaml")
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = g.AddFragment("library/postgres", []byte("services:\n  postgres:\n    image: library/postgres\n    networks:\n      - backend\nvolumes:\n  pgdata: {}\n"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	data, err := g.Marshal()
+	if err != nil {
+		t.Error(err)
+	}
+
+	out := string(data)
+	for _, s := range []string{"services:", "  nats:", "  postgres:", "networks:\n  backend: {}", "volumes:\n  pgdata: {}"} {
+		if !strings.Contains(out, s) {
+			t.Error(fmt.Errorf("Missing \"%s\" in result:\n%s", s, out))
+		}
+	}
+	if strings.Index(out, "  nats:") > strings.Index(out, "  postgres:") {
+		t.Error(fmt.Errorf("Services are not sorted:\n%s", out))
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	g := NewGenerator()
+
+	if err := g.AddFragment("a/one", []byte("services:\n  web:\n    image: one\nnetworks:\n  backend: {}\n")); err != nil {
+		t.Error(err)
+	}
+
+	// Equal networks may be shared
+	if err := g.AddFragment("a/two", []byte("networks:\n  backend: {}\n")); err != nil {
+		t.Error(err)
+	}
+
+	if err := g.AddFragment("a/three", []byte("services:\n  web:\n    image: three\n")); err == nil {
+		t.Error(fmt.Errorf("Duplicated service \"web\" hasn't been detected"))
+	}
+
+	if err := g.AddFragment("a/four", []byte("networks:\n  backend:\n    driver: overlay\n")); err == nil {
+		t.Error(fmt.Errorf("Conflicting network \"backend\" hasn't been detected"))
+	}
+}
+
+func TestTranslatePackageRefs(t *testing.T) {
+	g := NewGenerator()
+	g.AddPackage("minadmin/minadmin_pgsql", []string{"minadmin/minadmin"})
+	g.AddPackage("library/nats", []string{})
+
+	if err := g.AddFragmentFile("minadmin/minadmin_pgsql", "../examples/apps/minadmin/minadmin_pgsql/zemmpkg/compose.yaml"); err != nil {
+		t.Error(err)
+	}
+	if err := g.AddFragmentFile("library/nats", "../examples/apps/library/nats/zemmpkg/compose.yaml"); err != nil {
+		t.Error(err)
+	}
+
+	// library/postgres is not in the resolved set
+	if _, err := g.Marshal(); err == nil {
+		t.Error(fmt.Errorf("Missing package library/postgres hasn't been detected"))
+	}
+
+	g.AddPackage("tuatzemm/postgres_server", []string{"library/postgres"})
+	if err := g.AddFragment("tuatzemm/postgres_server", []byte("services:\n  pgsql:\n    image: library/postgres\n")); err != nil {
+		t.Error(err)
+	}
+
+	data, err := g.Marshal()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	out := string(data)
+	for _, s := range []string{"depends_on:\n    - nats\n    - pgsql", "links:\n    - nats:nats"} {
+		if !strings.Contains(out, s) {
+			t.Error(fmt.Errorf("Missing \"%s\" in result:\n%s", s, out))
+		}
+	}
+	if strings.Contains(out, "zemm_") {
+		t.Error(fmt.Errorf("zemm_ keys haven't been removed:\n%s", out))
+	}
+}
+
+func TestInjectSettings(t *testing.T) {
+	g := NewGenerator()
+	g.AddPackage("library/nats", []string{})
+	g.Inject("library/nats", Injection{
+		Environment: map[string]string{"NATS_PORT": "4333"},
+		Ports:       []string{"4333:4333"},
+		Volumes:     []string{"./data:/data"},
+	})
+
+	if err := g.AddFragment("library/nats", []byte("services:\n  nats:\n    image: nats\n    environment:\n      - NATS_PORT=4222\n      - DEBUG=1\n")); err != nil {
+		t.Error(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		data, err := g.Marshal()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		out := string(data)
+		for _, s := range []string{"environment:\n    - DEBUG=1\n    - NATS_PORT=4333\n", "ports:\n    - 4333:4333\n", "volumes:\n    - ./data:/data\n"} {
+			if !strings.Contains(out, s) {
+				t.Error(fmt.Errorf("Missing \"%s\" in result:\n%s", s, out))
+			}
+		}
+	}
+}
+
+func TestInjectDeclaredSettings(t *testing.T) {
+	p, lock := newTestProject(t, "settings:\n  library/nats:\n    NATS_PORT: 4333\n    DATA_DIR: /srv/nats\n", map[string]string{
+		pkg.FileName: `info:
+  name: library/nats
+  version: 2.1.9
+settings:
+  - name: NATS_PORT
+    type: port
+    default: 4222
+    port: 4222
+  - name: DATA_DIR
+    volume: /data
+  - name: MONITOR_PORT
+    type: port
+    port: 8222
+`,
+	})
+	defer os.RemoveAll(p.Dir())
+
+	inj, _, err := PackageSettings(p, lock.Packages[0], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inj.Ports) != 1 || inj.Ports[0] != "4333:4222" {
+		t.Error(fmt.Errorf("Invalid ports: %v", inj.Ports))
+	}
+	if len(inj.Volumes) != 1 || inj.Volumes[0] != "/srv/nats:/data" {
+		t.Error(fmt.Errorf("Invalid volumes: %v", inj.Volumes))
+	}
+	if inj.Environment["NATS_PORT"] != "4333" {
+		t.Error(fmt.Errorf("Invalid environment: %v", inj.Environment))
+	}
+
+	p.Settings["library/nats"]["NATS_PORT"] = "http"
+	if _, _, err := PackageSettings(p, lock.Packages[0], false); err == nil {
+		t.Error(fmt.Errorf("Invalid port hasn't been detected"))
+	}
+}
+
+func TestSubstituteFragment(t *testing.T) {
+	p, lock := newTestProject(t, "settings:\n  library/nats:\n    TAG: alpine\n", map[string]string{
+		FragmentPath:       "services:\n  nats:\n    image: nats:${VERSION}-${TAG}\n    command: $${CMD}\n",
+		"config/nats.conf": "version: ${VERSION}\n",
+	})
+	defer os.RemoveAll(p.Dir())
+
+	data, err := Generate(p, lock, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, s := range []string{"image: nats:2.1.9-alpine\n", "command: ${CMD}\n"} {
+		if !strings.Contains(out, s) {
+			t.Error(fmt.Errorf("Missing \"%s\" in result:\n%s", s, out))
+		}
+	}
+
+	// Only the compose fragment gets substituted
+	conf, err := ioutil.ReadFile(path.Join(install.PackageDir(p.Dir(), "library/nats"), "config/nats.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(conf) != "version: ${VERSION}\n" {
+		t.Error(fmt.Errorf("config/nats.conf should be left as it is: %s", conf))
+	}
+}
+
+func TestStatus(t *testing.T) {
+	lock := &install.Lock{Packages: []install.LockPackage{
+		{Name: "library/nats", Version: "2.1.9", List: "library/nats/2.1.9"},
+		{Name: "tuatzemm/settings_pgsql", Version: "1.0.0", List: "tuatzemm/suite/1.0.0", Provides: []string{"tuatzemm/settings"}},
+	}}
+
+	g := NewGenerator()
+	if err := g.AddFragment("library/nats", []byte("services:\n  nats:\n    image: nats\n")); err != nil {
+		t.Error(err)
+	}
+	if err := g.AddFragment("tuatzemm/settings_pgsql", []byte("services:\n  settings:\n    image: settings\n")); err != nil {
+		t.Error(err)
+	}
+
+	rt := runtime.NewFake("nats", "stray")
+	status, unmanaged, err := Status(g, lock, rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status) != 2 || len(status[0].Services[0].Containers) != 1 || status[0].Services[0].Containers[0].State != runtime.StateRunning {
+		t.Error(fmt.Errorf("nats should be running: %v", status))
+	}
+	if status[1].Services[0].Service != "settings" || len(status[1].Services[0].Containers) != 0 {
+		t.Error(fmt.Errorf("settings should have no container: %v", status[1]))
+	}
+	if len(unmanaged) != 1 || unmanaged[0].Service != "stray" {
+		t.Error(fmt.Errorf("Invalid unmanaged containers: %v", unmanaged))
+	}
+}
+
+// scaledRuntime runs every service with two containers
+type scaledRuntime struct {
+	*runtime.Fake
+}
+
+func (r scaledRuntime) Ps() ([]runtime.Container, error) {
+	containers, err := r.Fake.Ps()
+	result := []runtime.Container{}
+	for _, c := range containers {
+		second := c
+		second.ID, second.Name = c.ID+"-2", c.Service+"_2"
+		result = append(result, c, second)
+	}
+	return result, err
+}
+
+func TestStatusScaled(t *testing.T) {
+	lock := &install.Lock{Packages: []install.LockPackage{{Name: "library/nats", Version: "2.1.9", List: "library/nats/2.1.9"}}}
+
+	g := NewGenerator()
+	if err := g.AddFragment("library/nats", []byte("services:\n  nats:\n    image: nats\n")); err != nil {
+		t.Error(err)
+	}
+
+	status, _, err := Status(g, lock, scaledRuntime{runtime.NewFake("nats")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containers := status[0].Services[0].Containers
+	if len(containers) != 2 || containers[0].Name != "nats_1" || containers[1].Name != "nats_2" {
+		t.Error(fmt.Errorf("Both containers of nats should be listed: %v", containers))
+	}
+}
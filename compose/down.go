@@ -0,0 +1,86 @@
+package compose
+
+import (
+	"fmt"
+
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/runtime"
+)
+
+// ReverseOrder returns the locked packages, packages come before the packages
+// they depend on
+func ReverseOrder(lock *install.Lock) []string {
+	order := lock.Graph().TopologicalOrder()
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+
+	return order
+}
+
+// DownOrder returns the packages to tear down, packages come before the
+// packages they depend on. If only is set, just that package and those of its
+// dependencies that none of the other roots needs anymore are returned.
+func DownOrder(lock *install.Lock, roots []string, only string) ([]string, error) {
+	g := lock.Graph()
+	order := ReverseOrder(lock)
+
+	if only == "" {
+		return order, nil
+	}
+
+	if _, ok := lock.Package(only); !ok {
+		return nil, fmt.Errorf("Package \"%s\" is not installed", only)
+	}
+
+	remaining := []string{}
+	for _, r := range roots {
+		if r != only {
+			remaining = append(remaining, r)
+		}
+	}
+
+	keep := g.Reachable(remaining)
+	wanted := g.Reachable([]string{only})
+	if keep[only] {
+		required := []string{}
+		for _, d := range g.Dependents(only) {
+			if keep[d] {
+				required = append(required, d)
+			}
+		}
+		return nil, fmt.Errorf("Package \"%s\" is required by %v", only, required)
+	}
+
+	result := []string{}
+	for _, n := range order {
+		if wanted[n] && !keep[n] {
+			result = append(result, n)
+		}
+	}
+
+	return result, nil
+}
+
+// Down stops and removes the services of packages in the given order,
+// it returns the services that have been torn down
+func Down(rt runtime.Runtime, g *Generator, packages []string) ([]string, error) {
+	result := []string{}
+
+	for _, p := range packages {
+		services := g.Services(p)
+		if len(services) == 0 {
+			continue
+		}
+
+		if err := rt.Stop(services...); err != nil {
+			return result, fmt.Errorf("Failed to stop the services of package \"%s\": %v", p, err)
+		}
+		if err := rt.Remove(services...); err != nil {
+			return result, fmt.Errorf("Failed to remove the services of package \"%s\": %v", p, err)
+		}
+		result = append(result, services...)
+	}
+
+	return result, nil
+}
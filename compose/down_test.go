@@ -0,0 +1,95 @@
+package compose
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/runtime"
+)
+
+func testLock() *install.Lock {
+	return &install.Lock{Packages: []install.LockPackage{
+		{Name: "minadmin/app", Depends: []string{"library/nats", "tuatzemm/auth"}},
+		{Name: "tuatzemm/auth", Depends: []string{"tuatzemm/settings"}},
+		{Name: "tuatzemm/settings", Depends: []string{}},
+		{Name: "library/nats", Depends: []string{}},
+		{Name: "minadmin/agent", Depends: []string{"library/nats"}},
+	}}
+}
+
+func TestDownOrder(t *testing.T) {
+	roots := []string{"minadmin/app", "minadmin/agent"}
+
+	order, err := DownOrder(testLock(), roots, "")
+	if err != nil {
+		t.Error(err)
+	}
+	pos := make(map[string]int)
+	for i, n := range order {
+		pos[n] = i
+	}
+	for _, before := range [][2]string{
+		{"minadmin/app", "tuatzemm/auth"},
+		{"tuatzemm/auth", "tuatzemm/settings"},
+		{"minadmin/app", "library/nats"},
+		{"minadmin/agent", "library/nats"},
+	} {
+		if pos[before[0]] > pos[before[1]] {
+			t.Error(fmt.Errorf("%s must be torn down before %s: %v", before[0], before[1], order))
+		}
+	}
+
+	order, err = DownOrder(testLock(), roots, "minadmin/app")
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []string{"minadmin/app", "tuatzemm/auth", "tuatzemm/settings"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Error(fmt.Errorf("Got %v, expected %v", order, expected))
+	}
+
+	// minadmin/agent is not a root anymore but minadmin/app doesn't pull it in
+	order, err = DownOrder(testLock(), []string{"minadmin/app"}, "minadmin/app")
+	if err != nil {
+		t.Error(err)
+	}
+	expected = []string{"minadmin/app", "tuatzemm/auth", "tuatzemm/settings", "library/nats"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Error(fmt.Errorf("Got %v, expected %v", order, expected))
+	}
+
+	if _, err := DownOrder(testLock(), roots, "library/nats"); err == nil {
+		t.Error(fmt.Errorf("Tearing down a required package must fail"))
+	}
+}
+
+func TestDown(t *testing.T) {
+	g := NewGenerator()
+	for name, service := range map[string]string{"minadmin/app": "app", "tuatzemm/auth": "auth", "tuatzemm/settings": "settings"} {
+		if err := g.AddFragment(name, []byte(fmt.Sprintf("services:\n  %s:\n    image: %s\n", service, service))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	rt := runtime.NewFake("app", "auth", "settings", "nats")
+	order, err := DownOrder(testLock(), []string{"minadmin/app", "minadmin/agent"}, "minadmin/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := Down(rt, g, order)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(services, []string{"app", "auth", "settings"}) {
+		t.Error(fmt.Errorf("Invalid services torn down: %v", services))
+	}
+	if !reflect.DeepEqual(rt.Calls, []string{"stop app", "remove app", "stop auth", "remove auth", "stop settings", "remove settings"}) {
+		t.Error(fmt.Errorf("Invalid calls: %v", rt.Calls))
+	}
+	if _, ok := rt.Services["nats"]; !ok || len(rt.Services) != 1 {
+		t.Error(fmt.Errorf("Only nats should be left: %v", rt.Services))
+	}
+}
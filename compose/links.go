@@ -0,0 +1,178 @@
+package compose
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/go-multierror"
+	"gopkg.in/yaml.v2"
+)
+
+// PackageRef is an entry of zemm_depends_on and zemm_links
+type PackageRef struct {
+	Package string `yaml:"package"`
+	As      string `yaml:"as,omitempty"`
+}
+
+// AddPackage registers package name and the virtual packages it provides
+// as part of the resolved set, only those can be referenced by fragments
+func (g *Generator) AddPackage(name string, provides []string) {
+	g.packages[name] = name
+	for _, p := range provides {
+		if _, ok := g.packages[p]; !ok {
+			g.packages[p] = name
+		}
+	}
+}
+
+// packageServices returns the sorted service names of the package which
+// got selected for ref
+func (g *Generator) packageServices(ref string) (string, []string, error) {
+	pkg, ok := g.packages[ref]
+	if !ok {
+		return "", nil, fmt.Errorf("package \"%s\" is not in the resolved set", ref)
+	}
+
+	result := g.Services(pkg)
+	if len(result) == 0 {
+		return "", nil, fmt.Errorf("package \"%s\" (provided by \"%s\") has no services", ref, pkg)
+	}
+
+	return pkg, result, nil
+}
+
+// Services returns the sorted names of the services package pkg defines
+func (g *Generator) Services(pkg string) []string {
+	result := []string{}
+	for name, e := range g.sections["services"] {
+		if e.pkg == pkg {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func decodeRefs(value interface{}) ([]PackageRef, error) {
+	refs := []PackageRef{}
+
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &refs); err != nil {
+		return nil, err
+	}
+	for _, r := range refs {
+		if r.Package == "" {
+			return nil, fmt.Errorf("every entry needs a \"package\"")
+		}
+	}
+
+	return refs, nil
+}
+
+// appendToList adds values to a compose list or map (depends_on long syntax)
+func appendToList(existing interface{}, values []string) interface{} {
+	switch v := existing.(type) {
+	case yaml.MapSlice:
+		for _, n := range values {
+			v = append(v, yaml.MapItem{Key: n, Value: yaml.MapSlice{{Key: "condition", Value: "service_started"}}})
+		}
+		return v
+	case []interface{}:
+		for _, n := range values {
+			v = append(v, n)
+		}
+		return v
+	}
+
+	result := []interface{}{}
+	for _, n := range values {
+		result = append(result, n)
+	}
+	return result
+}
+
+// translateService replaces zemm_depends_on and zemm_links of a service with
+// depends_on and links to the services of the referenced packages
+func (g *Generator) translateService(name string, e entry) (interface{}, error) {
+	service, ok := e.value.(yaml.MapSlice)
+	if !ok {
+		return e.value, nil
+	}
+
+	result := &multierror.Error{}
+	dependsOn := []string{}
+	links := []string{}
+	out := yaml.MapSlice{}
+
+	for _, item := range service {
+		switch item.Key {
+		case "zemm_depends_on":
+			refs, err := decodeRefs(item.Value)
+			if err != nil {
+				result = multierror.Append(result, fmt.Errorf("Service \"%s\" of package \"%s\": zemm_depends_on: %v", name, e.pkg, err))
+				continue
+			}
+			for _, r := range refs {
+				_, services, err := g.packageServices(r.Package)
+				if err != nil {
+					result = multierror.Append(result, fmt.Errorf("Service \"%s\" of package \"%s\": zemm_depends_on: %v", name, e.pkg, err))
+					continue
+				}
+				dependsOn = append(dependsOn, services...)
+			}
+		case "zemm_links":
+			refs, err := decodeRefs(item.Value)
+			if err != nil {
+				result = multierror.Append(result, fmt.Errorf("Service \"%s\" of package \"%s\": zemm_links: %v", name, e.pkg, err))
+				continue
+			}
+			for _, r := range refs {
+				pkg, services, err := g.packageServices(r.Package)
+				if err != nil {
+					result = multierror.Append(result, fmt.Errorf("Service \"%s\" of package \"%s\": zemm_links: %v", name, e.pkg, err))
+					continue
+				}
+				if r.As != "" && len(services) > 1 {
+					result = multierror.Append(result, fmt.Errorf("Service \"%s\" of package \"%s\": zemm_links: can't link %d services of package \"%s\" as \"%s\"", name, e.pkg, len(services), pkg, r.As))
+					continue
+				}
+				for _, s := range services {
+					if r.As != "" {
+						links = append(links, fmt.Sprintf("%s:%s", s, r.As))
+					} else {
+						links = append(links, s)
+					}
+				}
+			}
+		default:
+			out = append(out, item)
+		}
+	}
+
+	for _, kv := range []struct {
+		key    string
+		values []string
+	}{{"depends_on", dependsOn}, {"links", links}} {
+		key, values := kv.key, kv.values
+		if len(values) == 0 {
+			continue
+		}
+
+		found := false
+		for i := range out {
+			if out[i].Key == key {
+				out[i].Value = appendToList(out[i].Value, values)
+				found = true
+			}
+		}
+		if !found {
+			out = append(out, yaml.MapItem{Key: key, Value: appendToList(nil, values)})
+		}
+	}
+
+	return out, result.ErrorOrNil()
+}
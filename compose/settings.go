@@ -0,0 +1,170 @@
+package compose
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/pkg"
+	"github.com/zemm-io/zemm/project"
+	"gopkg.in/yaml.v2"
+)
+
+// SecretMask replaces the values of secret settings when masking is enabled
+const SecretMask = "********"
+
+// Injection holds what gets injected into every service of a package
+type Injection struct {
+	Environment map[string]string
+	Ports       []string
+	Volumes     []string
+}
+
+// Inject registers inj for all services of package pkg
+func (g *Generator) Inject(pkg string, inj Injection) {
+	g.injections[pkg] = inj
+}
+
+// PackageSettings validates the settings the locked package lp declares against
+// the settings of the project, it returns what to inject and the values of the
+// declared settings as variables. Declared settings go into the environment,
+// published ports and mounted volumes into ports and volumes as well
+func PackageSettings(p *project.Project, lp install.LockPackage, mask bool) (Injection, map[string]string, error) {
+	result := &multierror.Error{}
+	vars := make(map[string]string)
+	inj := Injection{
+		Environment: p.Settings.Map(lp.Name, "environment"),
+		Ports:       p.Settings.List(lp.Name, "ports"),
+		Volumes:     p.Settings.List(lp.Name, "volumes"),
+	}
+
+	f := path.Join(install.PackageDir(p.Dir(), lp.Name), pkg.FileName)
+	if !common.FileExists(f) {
+		return inj, vars, nil
+	}
+
+	decl, err := pkg.NewPkg(f)
+	if err != nil {
+		return inj, vars, err
+	}
+
+	for _, s := range decl.Settings {
+		value, err := s.Value(p.Settings.Value(lp.Name, s.Name))
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("Package \"%s\": %v", lp.Name, err))
+			continue
+		}
+		if mask && s.Secret && value != "" {
+			value = SecretMask
+		}
+
+		vars[s.Name] = value
+		if value != "" {
+			inj.Environment[s.Name] = value
+		} else {
+			delete(inj.Environment, s.Name)
+		}
+
+		switch key, mapping := s.Mapping(value); key {
+		case "ports":
+			inj.Ports = append(inj.Ports, mapping)
+		case "volumes":
+			inj.Volumes = append(inj.Volumes, mapping)
+		}
+	}
+
+	return inj, vars, result.ErrorOrNil()
+}
+
+func appendStrings(existing interface{}, values []string) interface{} {
+	result := []interface{}{}
+	if l, ok := existing.([]interface{}); ok {
+		result = append(result, l...)
+	}
+	for _, v := range values {
+		result = append(result, v)
+	}
+
+	return result
+}
+
+// injectEnvironment sets env in a compose environment map or list
+func injectEnvironment(existing interface{}, env map[string]string) interface{} {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if l, ok := existing.([]interface{}); ok {
+		result := []interface{}{}
+		for _, item := range l {
+			s, isString := item.(string)
+			if _, ok := env[strings.SplitN(s, "=", 2)[0]]; isString && ok {
+				continue
+			}
+			result = append(result, item)
+		}
+		for _, k := range keys {
+			result = append(result, fmt.Sprintf("%s=%s", k, env[k]))
+		}
+		return result
+	}
+
+	result := yaml.MapSlice{}
+	if m, ok := existing.(yaml.MapSlice); ok {
+		result = append(result, m...)
+	}
+	for _, k := range keys {
+		found := false
+		for i := range result {
+			if fmt.Sprintf("%v", result[i].Key) == k {
+				result[i].Value = env[k]
+				found = true
+			}
+		}
+		if !found {
+			result = append(result, yaml.MapItem{Key: k, Value: env[k]})
+		}
+	}
+	return result
+}
+
+// injectService applies inj to the service definition value
+func injectService(value interface{}, inj Injection) interface{} {
+	service, ok := value.(yaml.MapSlice)
+	if !ok {
+		return value
+	}
+
+	for _, kv := range []struct {
+		key   string
+		empty bool
+		apply func(interface{}) interface{}
+	}{
+		{"environment", len(inj.Environment) == 0, func(v interface{}) interface{} { return injectEnvironment(v, inj.Environment) }},
+		{"ports", len(inj.Ports) == 0, func(v interface{}) interface{} { return appendStrings(v, inj.Ports) }},
+		{"volumes", len(inj.Volumes) == 0, func(v interface{}) interface{} { return appendStrings(v, inj.Volumes) }},
+	} {
+		if kv.empty {
+			continue
+		}
+
+		found := false
+		for i := range service {
+			if service[i].Key == kv.key {
+				service[i].Value = kv.apply(service[i].Value)
+				found = true
+			}
+		}
+		if !found {
+			service = append(service, yaml.MapItem{Key: kv.key, Value: kv.apply(nil)})
+		}
+	}
+
+	return service
+}
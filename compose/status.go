@@ -0,0 +1,66 @@
+package compose
+
+import (
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/runtime"
+)
+
+// ServiceStatus is a service of a package and its containers, a scaled
+// service has several
+type ServiceStatus struct {
+	Service    string              `json:"service"`
+	Containers []runtime.Container `json:"containers"`
+}
+
+// PackageStatus is an installed package with the state of its services
+type PackageStatus struct {
+	Package  string          `json:"package"`
+	Version  string          `json:"version"`
+	Provides []string        `json:"provides,omitempty"`
+	List     string          `json:"list"`
+	Services []ServiceStatus `json:"services"`
+}
+
+// Status joins the containers of rt with the packages in lock, containers
+// of services no package defines are returned as unmanaged
+func Status(g *Generator, lock *install.Lock, rt runtime.Runtime) ([]PackageStatus, []runtime.Container, error) {
+	containers, err := rt.Ps()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byService := make(map[string][]runtime.Container)
+	for _, c := range containers {
+		byService[c.Service] = append(byService[c.Service], c)
+	}
+
+	result := []PackageStatus{}
+	known := make(map[string]bool)
+	for _, lp := range lock.Packages {
+		ps := PackageStatus{
+			Package:  lp.Name,
+			Version:  lp.Version,
+			Provides: lp.Provides,
+			List:     lp.List,
+			Services: []ServiceStatus{},
+		}
+
+		for _, s := range g.Services(lp.Name) {
+			known[s] = true
+			ss := ServiceStatus{Service: s, Containers: []runtime.Container{}}
+			ss.Containers = append(ss.Containers, byService[s]...)
+			ps.Services = append(ps.Services, ss)
+		}
+
+		result = append(result, ps)
+	}
+
+	unmanaged := []runtime.Container{}
+	for _, c := range containers {
+		if !known[c.Service] {
+			unmanaged = append(unmanaged, c)
+		}
+	}
+
+	return result, unmanaged, nil
+}
@@ -0,0 +1,93 @@
+// Package events defines the structured events zemm emits while building,
+// resolving, installing and starting packages, the CLI renders them as
+// progress and the daemon streams them to its clients.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	ListFetched      = "list-fetched"
+	PackageResolved  = "package-resolved"
+	DownloadProgress = "download-progress"
+	ExtractDone      = "extract-done"
+	PackageRead      = "package-read"
+	PackageBuilt     = "package-built"
+	ServiceStarted   = "service-started"
+	Error            = "error"
+)
+
+type Event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Job     string    `json:"job,omitempty"`
+	List    string    `json:"list,omitempty"`
+	Package string    `json:"package,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Service string    `json:"service,omitempty"`
+	// Bytes downloaded so far and the Total size, -1 if unknown
+	Bytes   int64  `json:"bytes,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Sink receives events, a nil Sink discards them
+type Sink func(Event)
+
+// Emit sets the time of e and sends it to s
+func (s Sink) Emit(e Event) {
+	if s == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	s(e)
+}
+
+// Bus distributes the events sent to its Sink to all subscribers
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[chan Event]bool)}
+}
+
+// Sink returns the Sink publishing to all subscribers, slow subscribers
+// miss events instead of blocking the sender
+func (b *Bus) Sink() Sink {
+	return func(e Event) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		for ch := range b.subscribers {
+			select {
+			case ch <- e:
+			default:
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel receiving all events and a function to unsubscribe
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+
+	b.mu.Lock()
+	b.subscribers[ch] = true
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
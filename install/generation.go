@@ -0,0 +1,381 @@
+package install
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/project"
+)
+
+const (
+	// GenerationsDir holds a directory per install, relative to StateDir
+	GenerationsDir = "generations"
+	// CurrentLink is the symlink to the active generation, relative to StateDir
+	CurrentLink = "current"
+	// GenerationFileName is the metadata file of a generation
+	GenerationFileName = "generation.json"
+	// DefaultKeepGenerations is the number of previous generations kept
+	DefaultKeepGenerations = 3
+)
+
+// Generation is the result of an install, it holds the package directories,
+// the lockfile and the zemm files they were built from
+type Generation struct {
+	Number   int       `json:"number"`
+	Created  time.Time `json:"created"`
+	Packages int       `json:"packages"`
+	Current  bool      `json:"-"`
+}
+
+// GenerationDir returns the directory of generation n
+func GenerationDir(projectDir string, n int) string {
+	return path.Join(projectDir, StateDir, GenerationsDir, strconv.Itoa(n))
+}
+
+// CurrentGeneration returns the number of the active generation, 0 if there is none
+func CurrentGeneration(projectDir string) (int, error) {
+	target, err := os.Readlink(path.Join(projectDir, StateDir, CurrentLink))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(filepath.Base(target))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid generation link \"%s\"", target)
+	}
+
+	return n, nil
+}
+
+// Generations returns all generations of the project, oldest first
+func Generations(projectDir string) ([]Generation, error) {
+	current, err := CurrentGeneration(projectDir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(path.Join(projectDir, StateDir, GenerationsDir))
+	if os.IsNotExist(err) {
+		return []Generation{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Generation{}
+	for _, e := range entries {
+		n, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+
+		g := Generation{}
+		if err := common.URLToStruct(path.Join(GenerationDir(projectDir, n), GenerationFileName), &g); err != nil {
+			// Incomplete generation
+			continue
+		}
+		g.Number = n
+		g.Current = n == current
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Number < result[j].Number
+	})
+
+	return result, nil
+}
+
+// newGeneration creates the directory of the next generation
+func newGeneration(projectDir string) (int, string, error) {
+	n := 1
+	entries, err := ioutil.ReadDir(path.Join(projectDir, StateDir, GenerationsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, "", err
+	}
+	for _, e := range entries {
+		if i, err := strconv.Atoi(e.Name()); err == nil && i >= n {
+			n = i + 1
+		}
+	}
+
+	dir := GenerationDir(projectDir, n)
+	if err := os.MkdirAll(dir, os.ModeDir|(common.OS_USER_RWX|common.OS_GROUP_RX|common.OS_OTH_RX)); err != nil {
+		return 0, "", err
+	}
+
+	return n, dir, nil
+}
+
+// switchGeneration atomically points the current link to generation n
+func switchGeneration(projectDir string, n int) error {
+	link := path.Join(projectDir, StateDir, CurrentLink)
+	tmp := link + ".tmp"
+
+	os.Remove(tmp)
+	if err := os.Symlink(path.Join(GenerationsDir, strconv.Itoa(n)), tmp); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, link)
+}
+
+// buildGeneration creates the package directories of lock in genDir and
+// saves the lockfile and the zemm files with it
+func (i *Installer) buildGeneration(genDir string, result *Result, lock *Lock) error {
+	if err := i.buildPackageDirs(result, lock, path.Join(genDir, PackagesDir)); err != nil {
+		return err
+	}
+
+	if err := lock.Write(genDir); err != nil {
+		return err
+	}
+
+	snapshot, err := i.Project.Snapshot()
+	if err != nil {
+		return err
+	}
+	for f, contents := range snapshot {
+		if err := ioutil.WriteFile(path.Join(genDir, path.Base(f)), contents, common.OS_USER_RW|common.OS_GROUP_R); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(Generation{Created: time.Now(), Packages: len(lock.Packages)}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(genDir, GenerationFileName), data, common.OS_USER_RW|common.OS_GROUP_R|common.OS_OTH_R)
+}
+
+// unchanged reports if generation current has been built from lock and
+// the current zemm files, in that case no new generation is needed
+func (i *Installer) unchanged(current int, lock *Lock) (bool, error) {
+	if current == 0 {
+		return false, nil
+	}
+	genDir := GenerationDir(i.Project.Dir(), current)
+
+	data, err := lock.Marshal()
+	if err != nil {
+		return false, err
+	}
+	saved, err := ioutil.ReadFile(path.Join(genDir, LockFileName))
+	if err != nil || !bytes.Equal(data, saved) {
+		return false, nil
+	}
+
+	snapshot, err := i.Project.Snapshot()
+	if err != nil {
+		return false, err
+	}
+	for _, name := range []string{project.FileName, project.LocalFileName} {
+		contents, known := snapshot[path.Join(i.Project.Dir(), name)]
+		saved, err := ioutil.ReadFile(path.Join(genDir, name))
+		if known != (err == nil) || !bytes.Equal(contents, saved) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// pruneGenerations removes all but the current and the keep newest other
+// generations and the store entries none of the remaining ones uses
+func pruneGenerations(projectDir string, keep int) error {
+	generations, err := Generations(projectDir)
+	if err != nil {
+		return err
+	}
+
+	result := &multierror.Error{}
+	used := make(map[string]bool)
+	kept := 0
+	for j := len(generations) - 1; j >= 0; j-- {
+		g := generations[j]
+		if !g.Current {
+			if kept >= keep {
+				if err := os.RemoveAll(GenerationDir(projectDir, g.Number)); err != nil {
+					result = multierror.Append(result, err)
+				}
+				continue
+			}
+			kept++
+		}
+
+		lock, err := ReadLock(GenerationDir(projectDir, g.Number))
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		for _, lp := range lock.Packages {
+			used[StorePackageDir(projectDir, lp.Name, lp.Version)] = true
+		}
+	}
+	if result.ErrorOrNil() != nil {
+		// Don't remove anything from the store if unsure what's used
+		return result
+	}
+
+	versions, err := filepath.Glob(path.Join(projectDir, StateDir, StoreDir, "*", "*", "*"))
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if used[v] {
+			continue
+		}
+		if err := os.RemoveAll(v); err != nil {
+			result = multierror.Append(result, err)
+		}
+		// Only succeeds if it's empty
+		os.Remove(path.Dir(v))
+	}
+
+	return result.ErrorOrNil()
+}
+
+// Rollback switches to generation n, 0 means the generation before the
+// current one, and restores the lockfile, zemm files and installed state it
+// was built from, on failure the current generation and its files are restored
+func Rollback(projectDir string, n int) (*Generation, error) {
+	generations, err := Generations(projectDir)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := CurrentGeneration(projectDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *Generation
+	for j := range generations {
+		g := &generations[j]
+		if (n == 0 && g.Number < current) || (n != 0 && g.Number == n) {
+			target = g
+		}
+	}
+	if target == nil {
+		if n == 0 {
+			return nil, fmt.Errorf("There is no generation before the current generation %d", current)
+		}
+		return nil, fmt.Errorf("Unknown generation %d", n)
+	}
+
+	genDir := GenerationDir(projectDir, target.Number)
+	state, err := readStateFile(path.Join(genDir, InstalledFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	// Files the target generation doesn't have are removed (nil)
+	files := map[string][]byte{}
+	for _, name := range []string{project.FileName, project.LocalFileName, LockFileName} {
+		contents, err := ioutil.ReadFile(path.Join(genDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		files[path.Join(projectDir, name)] = contents
+	}
+	previous, err := readFiles(append(fileNames(files), StatePath(projectDir)))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := switchGeneration(projectDir, target.Number); err != nil {
+		return nil, err
+	}
+	err = writeFiles(files)
+	if err == nil {
+		err = state.Write(projectDir)
+	}
+	if err != nil {
+		result := multierror.Append(&multierror.Error{}, err)
+		if current != 0 {
+			if sErr := switchGeneration(projectDir, current); sErr != nil {
+				result = multierror.Append(result, sErr)
+			}
+		}
+		if rErr := writeFiles(previous); rErr != nil {
+			result = multierror.Append(result, rErr)
+		}
+		return nil, result.ErrorOrNil()
+	}
+	target.Current = true
+
+	return target, nil
+}
+
+func fileNames(files map[string][]byte) []string {
+	result := []string{}
+	for f := range files {
+		result = append(result, f)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// readFiles reads the contents of files, missing ones are nil
+func readFiles(files []string) (map[string][]byte, error) {
+	result := map[string][]byte{}
+	for _, f := range files {
+		contents, err := ioutil.ReadFile(f)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		result[f] = contents
+	}
+
+	return result, nil
+}
+
+// writeFiles writes the contents of files, files without contents (nil) get removed
+func writeFiles(files map[string][]byte) error {
+	result := &multierror.Error{}
+	for _, f := range fileNames(files) {
+		if files[f] == nil {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				result = multierror.Append(result, err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(f, files[f], common.OS_USER_RW|common.OS_GROUP_R|common.OS_OTH_R); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// Restore makes generation n current again, like Rollback, 0 deactivates
+// all generations which leaves nothing installed
+func Restore(projectDir string, n int) error {
+	if n != 0 {
+		_, err := Rollback(projectDir, n)
+		return err
+	}
+
+	result := &multierror.Error{}
+	for _, f := range []string{path.Join(projectDir, StateDir, CurrentLink), path.Join(projectDir, LockFileName), StatePath(projectDir)} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result.ErrorOrNil()
+}
@@ -0,0 +1,490 @@
+package install
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mholt/archiver/v3"
+	"github.com/tpazderka/warning"
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/events"
+	"github.com/zemm-io/zemm/overlay"
+	"github.com/zemm-io/zemm/pkg"
+	"github.com/zemm-io/zemm/pm"
+	"github.com/zemm-io/zemm/project"
+)
+
+const (
+	// StateDir is the directory zemm keeps its files in, relative to the project
+	StateDir = ".zemm"
+	// StoreDir is the directory packages get extracted to, relative to StateDir
+	StoreDir = "store"
+	// PackagesDir is the directory with the packages including overlays, relative to a generation
+	PackagesDir = "packages"
+)
+
+type Change struct {
+	Name       string `json:"name"`
+	OldVersion string `json:"old_version,omitempty"`
+	NewVersion string `json:"new_version,omitempty"`
+}
+
+type Result struct {
+	Packages   []*pm.RPackage   `json:"-"`
+	Added      []Change         `json:"added"`
+	Upgraded   []Change         `json:"upgraded"`
+	Downgraded []Change         `json:"downgraded"`
+	Updated    []Change         `json:"updated"`
+	Removed    []Change         `json:"removed"`
+	Overlaid   []overlay.Change `json:"overlaid"`
+	// Recommended are the packages only installed because of recommends
+	Recommended []string `json:"recommended"`
+	// Preferred are the providers selected by "prefer"
+	Preferred []pm.Decision `json:"preferred"`
+	Warnings  []error       `json:"-"`
+}
+
+type Installer struct {
+	Project    *project.Project
+	Recommends bool
+	// Refresh downloads packages again even if the locked version is installed
+	Refresh bool
+	// AllowEmpty removes all packages instead of failing when there is nothing to install
+	AllowEmpty bool
+	// Events receives the progress of resolving, downloading and extracting
+	Events events.Sink
+}
+
+func NewInstaller(p *project.Project) *Installer {
+	return &Installer{Project: p, Recommends: true}
+}
+
+// PackageDir returns the directory of package name with all overlays applied
+// in the current generation
+func PackageDir(projectDir, name string) string {
+	return path.Join(projectDir, StateDir, CurrentLink, PackagesDir, name)
+}
+
+// StorePackageDir returns the directory version of package name gets extracted to
+func StorePackageDir(projectDir, name, version string) string {
+	return path.Join(projectDir, StateDir, StoreDir, name, version)
+}
+
+// PackageVersion returns the version of p that gets installed, respecting
+// "package@version" overrides of the project
+func (i *Installer) PackageVersion(p *pm.RPackage) string {
+	if v, ok := i.Project.Overrides[p.Name]; ok {
+		return v
+	}
+
+	return p.Version
+}
+
+// NewPackageManager creates a package manager with all lists of the project added
+func (i *Installer) NewPackageManager() (*pm.PackageManager, error) {
+	index, err := i.Project.Index(project.DefaultIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr, err := pm.NewPackageManager()
+	if err != nil {
+		return nil, err
+	}
+	mgr.SetEvents(i.Events)
+	mgr.SetProviders(i.Project.Providers)
+	if err := mgr.SetPrefer(i.Project.Prefer); err != nil {
+		return nil, err
+	}
+
+	lists := i.Project.AllLists()
+	if len(lists) == 0 {
+		return nil, fmt.Errorf("No lists defined in %s", project.FileName)
+	}
+
+	result := &multierror.Error{}
+	for _, l := range lists {
+		if err := mgr.AddRepository(index, l); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if err := result.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+
+	if err := mgr.Validate(); err != nil {
+		return nil, err
+	}
+
+	return mgr, nil
+}
+
+// Requests returns the packages to install, Recommends is the default for
+// packages without a "recommends" setting
+func (i *Installer) Requests() []pm.Request {
+	result := make([]pm.Request, len(i.Project.Install))
+	for n, inst := range i.Project.Install {
+		result[n] = pm.Request{Package: inst.Package, Recommends: i.Recommends}
+		if inst.Recommends != nil {
+			result[n].Recommends = *inst.Recommends
+		}
+	}
+
+	return result
+}
+
+// Resolve returns all packages the project needs, the returned warnings
+// are not fatal
+func (i *Installer) Resolve(mgr *pm.PackageManager) ([]*pm.RPackage, []error, error) {
+	if len(i.Project.Install) == 0 {
+		if i.AllowEmpty {
+			return []*pm.RPackage{}, nil, nil
+		}
+		return nil, nil, fmt.Errorf("Nothing to install, add packages to \"install\" in %s", project.FileName)
+	}
+
+	pkgs, rErr := mgr.GetDependenciesFor(i.Requests(), i.Project.Without)
+
+	// Only apps can change the files of other packages
+	types := make(map[string]string)
+	for _, p := range pkgs {
+		types[p.Name] = p.Kind()
+	}
+	for _, inst := range i.Project.Install {
+		if typ, ok := types[inst.Package]; ok && inst.Overlay && typ != pm.TypeApp {
+			rErr = multierror.Append(rErr, fmt.Errorf("Package \"%s\" is a %s, only an %s can be an overlay", inst.Package, typ, pm.TypeApp))
+		}
+	}
+
+	warnings, err := common.SplitWarnings(rErr.ErrorOrNil())
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	return pkgs, warnings, nil
+}
+
+// Plan resolves the packages of the project and compares them with the
+// lockfile, it doesn't download or change anything
+func (i *Installer) Plan() (*Result, error) {
+	result, _, err := i.plan()
+	return result, err
+}
+
+func (i *Installer) plan() (*Result, *Lock, error) {
+	mgr, err := i.NewPackageManager()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkgs, warnings, err := i.Resolve(mgr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oldLock, err := ReadLock(i.Project.Dir())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &Result{
+		Packages:    pkgs,
+		Added:       []Change{},
+		Upgraded:    []Change{},
+		Downgraded:  []Change{},
+		Updated:     []Change{},
+		Removed:     []Change{},
+		Overlaid:    []overlay.Change{},
+		Recommended: mgr.RecommendsOnly(),
+		Preferred:   mgr.Preferred(),
+		Warnings:    warnings,
+	}
+
+	names := make(map[string]int)
+	for _, p := range pkgs {
+		names[p.Name] = 0
+		version := i.PackageVersion(p)
+
+		old, known := oldLock.Package(p.Name)
+		switch {
+		case !known:
+			result.Added = append(result.Added, Change{Name: p.Name, NewVersion: version})
+		case common.CompareVersions(version, old.Version) < 0:
+			result.Downgraded = append(result.Downgraded, Change{Name: p.Name, OldVersion: old.Version, NewVersion: version})
+		case old.Version != version:
+			result.Upgraded = append(result.Upgraded, Change{Name: p.Name, OldVersion: old.Version, NewVersion: version})
+		}
+	}
+
+	for _, old := range oldLock.Packages {
+		if _, ok := names[old.Name]; !ok {
+			result.Removed = append(result.Removed, Change{Name: old.Name, OldVersion: old.Version})
+		}
+	}
+
+	return result, oldLock, nil
+}
+
+// Run resolves, downloads and extracts all packages of the project and
+// writes the lockfile
+func (i *Installer) Run() (*Result, error) {
+	result, err := i.run()
+	if err != nil {
+		i.Events.Emit(events.Event{Type: events.Error, Message: err.Error()})
+	}
+
+	return result, err
+}
+
+func (i *Installer) run() (*Result, error) {
+	dir := i.Project.Dir()
+
+	result, oldLock, err := i.plan()
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := i.Project.Index(project.DefaultIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(path.Join(dir, StateDir), os.ModeDir|(common.OS_USER_RWX|common.OS_GROUP_RX|common.OS_OTH_RX)); err != nil {
+		return nil, err
+	}
+	tmpDir, err := ioutil.TempDir(path.Join(dir, StateDir), "tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	current, err := CurrentGeneration(dir)
+	if err != nil {
+		return nil, err
+	}
+	if current == 0 {
+		// Remove what installs before generations left behind
+		for _, d := range []string{StoreDir, PackagesDir} {
+			if err := os.RemoveAll(path.Join(dir, StateDir, d)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	newLock := &Lock{Lists: i.Project.AllLists(), Packages: []LockPackage{}}
+	staged := []LockPackage{}
+	// Recommends are enabled per package, recommends edges only point to
+	// packages that got selected
+	graph := pm.NewGraph(result.Packages, true)
+
+	// Download and extract everything into tmpDir first so a failing
+	// download doesn't leave a half installed project behind
+	for _, p := range result.Packages {
+		version := i.PackageVersion(p)
+		lp := LockPackage{
+			Name:     p.Name,
+			Version:  version,
+			List:     p.Repository.GetList(),
+			Provides: p.ProvidedNames(),
+			Depends:  graph.Edges[p.Name],
+		}
+		stored := common.DirExists(StorePackageDir(dir, p.Name, version))
+
+		old, known := oldLock.Package(p.Name)
+		if !i.Refresh && known && old.Version == version && stored {
+			lp.Digest = old.Digest
+			newLock.Packages = append(newLock.Packages, lp)
+			continue
+		}
+
+		digest, err := fetchPackage(index, p, version, tmpDir, i.Events)
+		if err != nil {
+			return nil, err
+		}
+		lp.Digest = digest
+		newLock.Packages = append(newLock.Packages, lp)
+
+		if known && old.Version == version {
+			if old.Digest == digest && stored {
+				// Refreshed but nothing changed
+				continue
+			}
+			result.Updated = append(result.Updated, Change{Name: p.Name, OldVersion: old.Version, NewVersion: version})
+		}
+		staged = append(staged, lp)
+	}
+
+	// Move the staged packages into the store, generations have their own
+	// copies so replacing a version doesn't change them
+	for _, lp := range staged {
+		dst := StorePackageDir(dir, lp.Name, lp.Version)
+		if err := os.RemoveAll(dst); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(path.Dir(dst), os.ModeDir|(common.OS_USER_RWX|common.OS_GROUP_RX|common.OS_OTH_RX)); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(path.Join(tmpDir, "extract", lp.Name), dst); err != nil {
+			return nil, err
+		}
+	}
+
+	return i.commit(result, newLock, current)
+}
+
+// commit builds the packages of newLock into a new generation and switches
+// to it, nothing happens if generation current already matches newLock
+func (i *Installer) commit(result *Result, newLock *Lock, current int) (*Result, error) {
+	dir := i.Project.Dir()
+
+	oldState, err := ReadState(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	unchanged, err := i.unchanged(current, newLock)
+	if err != nil {
+		return nil, err
+	}
+	if unchanged {
+		if !common.FileExists(StatePath(dir)) {
+			state, err := i.newState(current, result, newLock, path.Join(GenerationDir(dir, current), PackagesDir), oldState)
+			if err != nil {
+				return nil, err
+			}
+			if err := state.Write(dir); err != nil {
+				return nil, err
+			}
+		}
+		return result, newLock.Write(dir)
+	}
+
+	// Build the new generation and switch to it once it's complete
+	n, genDir, err := newGeneration(dir)
+	if err != nil {
+		return nil, err
+	}
+	state, err := func() (*State, error) {
+		if err := i.buildGeneration(genDir, result, newLock); err != nil {
+			return nil, err
+		}
+		state, err := i.newState(n, result, newLock, path.Join(genDir, PackagesDir), oldState)
+		if err != nil {
+			return nil, err
+		}
+		return state, state.writeFile(path.Join(genDir, InstalledFileName))
+	}()
+	if err != nil {
+		os.RemoveAll(genDir)
+		return nil, err
+	}
+	if err := switchGeneration(dir, n); err != nil {
+		os.RemoveAll(genDir)
+		return nil, err
+	}
+
+	if err := newLock.Write(dir); err != nil {
+		return nil, err
+	}
+	if err := state.Write(dir); err != nil {
+		return nil, err
+	}
+
+	keep := i.Project.KeepGenerations
+	if keep <= 0 {
+		keep = DefaultKeepGenerations
+	}
+	if err := pruneGenerations(dir, keep); err != nil {
+		result.Warnings = append(result.Warnings, warning.Wrap(fmt.Errorf("Failed to remove old generations: %v", err)))
+	}
+
+	return result, nil
+}
+
+// fetchPackage downloads the archive of the list entry in version from index,
+// checks it matches the entry and extracts it to tmpDir/extract/name, it
+// returns the sha256 digest of the archive
+func fetchPackage(index string, entry *pm.RPackage, version, tmpDir string, sink events.Sink) (string, error) {
+	name := entry.Name
+	src := common.URLAndPathJoin(index, path.Join("packages", name, version+".txz"))
+	archive := path.Join(tmpDir, "download", name, version+".txz")
+
+	progress := func(done, total int64) {
+		sink.Emit(events.Event{Type: events.DownloadProgress, Package: name, Version: version, Bytes: done, Total: total})
+	}
+	if err := common.DownloadURLToFileWithProgress(src, archive, progress); err != nil {
+		return "", fmt.Errorf("Failed to download package \"%s\" version \"%s\": %v", name, version, err)
+	}
+
+	digest, err := common.FileSHA256(archive)
+	if err != nil {
+		return "", err
+	}
+
+	// The list entry has to describe the package, other versions of
+	// "package@version" overrides are not in the list
+	if version == entry.Version {
+		embedded, err := pkg.ReadArchive(archive, sink)
+		if err != nil {
+			return "", err
+		}
+		if err := embedded.MatchListEntry(entry); err != nil {
+			return "", fmt.Errorf("Package \"%s\" version \"%s\" doesn't match its list \"%s\": %v", name, version, entry.Repository.GetList(), err)
+		}
+	}
+
+	dst := path.Join(tmpDir, "extract", name)
+	if err := os.MkdirAll(dst, os.ModeDir|(common.OS_USER_RWX|common.OS_GROUP_RX|common.OS_OTH_RX)); err != nil {
+		return "", err
+	}
+	if err := archiver.Unarchive(archive, dst); err != nil {
+		return "", fmt.Errorf("Failed to extract package \"%s\" version \"%s\": %v", name, version, err)
+	}
+	sink.Emit(events.Event{Type: events.ExtractDone, Package: name, Version: version})
+
+	return digest, nil
+}
+
+// buildPackageDirs creates the package directories in packagesDir from the
+// store and applies the overlays of all packages installed with "overlay: true"
+func (i *Installer) buildPackageDirs(result *Result, lock *Lock, packagesDir string) error {
+	dir := i.Project.Dir()
+	rErr := &multierror.Error{}
+
+	layers := []overlay.Layer{}
+	for _, inst := range i.Project.Install {
+		if !inst.Overlay {
+			continue
+		}
+		lp, ok := lock.Package(inst.Package)
+		if !ok {
+			continue
+		}
+		l := overlay.Layer{Package: inst.Package, Root: StorePackageDir(dir, lp.Name, lp.Version)}
+		layers = append(layers, l)
+
+		targets, err := overlay.Targets(l)
+		if err != nil {
+			return err
+		}
+		for _, t := range targets {
+			if _, ok := lock.Package(t); !ok {
+				result.Warnings = append(result.Warnings, warning.Wrap(fmt.Errorf("Package \"%s\" overlays \"%s\" which is not installed", inst.Package, t)))
+			}
+		}
+	}
+
+	for _, lp := range lock.Packages {
+		changes, err := overlay.Build(lp.Name, StorePackageDir(dir, lp.Name, lp.Version), path.Join(packagesDir, lp.Name), layers)
+		if err != nil {
+			rErr = multierror.Append(rErr, err)
+			continue
+		}
+		result.Overlaid = append(result.Overlaid, changes...)
+	}
+
+	return rErr.ErrorOrNil()
+}
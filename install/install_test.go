@@ -0,0 +1,247 @@
+package install
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/events"
+	"github.com/zemm-io/zemm/pm"
+	"github.com/zemm-io/zemm/project"
+)
+
+func newTestProject(t *testing.T, list string, packages ...string) string {
+	dir, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := filepath.Abs("../examples/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := fmt.Sprintf("version: 1.0\nindexes:\n  zemm: %s\nlists:\n  main: %s\ninstall:\n", repo, list)
+	for _, p := range packages {
+		content += fmt.Sprintf("  - package: %s\n", p)
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, project.FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestInstallNats(t *testing.T) {
+	dir := newTestProject(t, "library/nats/2.1.9", "library/nats")
+	defer os.RemoveAll(dir)
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := []string{}
+	i := NewInstaller(p)
+	i.Events = func(e events.Event) {
+		if len(seen) == 0 || seen[len(seen)-1] != e.Type {
+			seen = append(seen, e.Type)
+		}
+	}
+	result, err := i.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{events.ListFetched, events.PackageResolved, events.DownloadProgress, events.PackageRead, events.ExtractDone}
+	if fmt.Sprint(seen) != fmt.Sprint(expected) {
+		t.Error(fmt.Errorf("Expected the events %v, got %v", expected, seen))
+	}
+
+	if len(result.Added) != 1 || result.Added[0].Name != "library/nats" || result.Added[0].NewVersion != "2.1.9" {
+		t.Error(fmt.Errorf("Invalid added packages: %v", result.Added))
+	}
+
+	if !common.FileExists(path.Join(PackageDir(dir, "library/nats"), "zemmpkg", "compose.yaml")) {
+		t.Error(fmt.Errorf("Package library/nats has not been extracted"))
+	}
+
+	lock, err := ReadLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp, ok := lock.Package("library/nats")
+	if !ok || lp.Version != "2.1.9" || lp.List != "library/nats/2.1.9" || lp.Digest == "" {
+		t.Error(fmt.Errorf("Invalid lock entry: %v", lp))
+	}
+
+	// A second run must not change anything
+	result, err = NewInstaller(p).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added)+len(result.Upgraded)+len(result.Removed) != 0 {
+		t.Error(fmt.Errorf("Second install changed something: %v", result))
+	}
+}
+
+func TestInstallUnknownPackage(t *testing.T) {
+	dir := newTestProject(t, "library/nats/2.1.9", "library/unknown")
+	defer os.RemoveAll(dir)
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewInstaller(p).Run(); err == nil {
+		t.Error(fmt.Errorf("Installing an unknown package should fail"))
+	}
+}
+
+func TestGenerationsAndRollback(t *testing.T) {
+	dir := newTestProject(t, "library/nats/2.1.9", "library/nats")
+	defer os.RemoveAll(dir)
+
+	original, err := ioutil.ReadFile(path.Join(dir, project.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 1; n <= 4; n++ {
+		// Every changed zemm.yaml results in a new generation
+		content := append(append([]byte{}, original...), []byte(fmt.Sprintf("keep_generations: 2\nsettings:\n  library/nats:\n    run: %d\n", n))...)
+		if err := ioutil.WriteFile(path.Join(dir, project.FileName), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		p, err := project.Load(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewInstaller(p).Run(); err != nil {
+			t.Fatal(err)
+		}
+		// Running again without changes keeps the generation
+		if _, err := NewInstaller(p).Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		if current, err := CurrentGeneration(dir); err != nil || current != n {
+			t.Fatal(fmt.Errorf("Current generation should be %d, got %d: %v", n, current, err))
+		}
+	}
+
+	generations, err := Generations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(generations) != 3 || generations[0].Number != 2 || !generations[2].Current {
+		t.Error(fmt.Errorf("Old generations have not been pruned: %v", generations))
+	}
+
+	g, err := Rollback(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := CurrentGeneration(dir); g.Number != 3 || current != 3 {
+		t.Error(fmt.Errorf("Rollback should switch to generation 3, got %d", current))
+	}
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Settings["library/nats"]["run"] != 3 {
+		t.Error(fmt.Errorf("%s has not been restored: %v", project.FileName, p.Settings))
+	}
+	if !common.FileExists(path.Join(PackageDir(dir, "library/nats"), "zemmpkg", "compose.yaml")) {
+		t.Error(fmt.Errorf("Package library/nats is missing after the rollback"))
+	}
+
+	if _, err := Rollback(dir, 1); err == nil {
+		t.Error(fmt.Errorf("Rolling back to a pruned generation should fail"))
+	}
+
+	// Generation 4 has no local.zemm.yaml, the current one gets removed
+	if err := ioutil.WriteFile(path.Join(dir, project.LocalFileName), []byte("version: 1.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rollback(dir, 4); err != nil {
+		t.Fatal(err)
+	}
+	if common.FileExists(path.Join(dir, project.LocalFileName)) {
+		t.Error(fmt.Errorf("%s should have been removed by the rollback", project.LocalFileName))
+	}
+}
+
+func TestInstalledState(t *testing.T) {
+	dir := newTestProject(t, "library/nats/2.1.9", "library/nats")
+	defer os.RemoveAll(dir)
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewInstaller(p).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, ok := state.Package("library/nats")
+	if !ok || ip.Version != "2.1.9" || ip.Reason != ReasonExplicit || ip.List != "library/nats/2.1.9" || ip.ListIsDependency || state.Generation != 1 {
+		t.Error(fmt.Errorf("Invalid state: %v", state))
+	}
+
+	if owner, ok := state.Owner("library/nats/zemmpkg/compose.yaml"); !ok || owner != "library/nats" {
+		t.Error(fmt.Errorf("library/nats should own its compose.yaml, got \"%s\"", owner))
+	}
+	if _, ok := state.Owner("library/nats/unknown"); ok {
+		t.Error(fmt.Errorf("Unknown files must not have an owner"))
+	}
+}
+
+func TestOnlyAppsOverlay(t *testing.T) {
+	index := newTestIndex(t)
+	defer os.RemoveAll(index)
+	dir := installTestIndex(t, index, "test/app", "test/tool")
+	defer os.RemoveAll(dir)
+
+	state, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app, _ := state.Package("test/app"); app.Type != pm.TypeApp {
+		t.Error(fmt.Errorf("test/app should be an app, got \"%s\"", app.Type))
+	}
+	if tool, _ := state.Package("test/tool"); tool.Type != pm.TypeService {
+		t.Error(fmt.Errorf("test/tool should be a service, got \"%s\"", tool.Type))
+	}
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddInstall(project.Install{Package: "test/tool", Overlay: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewInstaller(p).Run(); err == nil {
+		t.Error(fmt.Errorf("The service test/tool must not be an overlay"))
+	}
+
+	if err := p.AddInstall(project.Install{Package: "test/tool"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddInstall(project.Install{Package: "test/app", Overlay: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewInstaller(p).Run(); err != nil {
+		t.Error(err)
+	}
+}
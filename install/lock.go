@@ -0,0 +1,93 @@
+package install
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/pm"
+)
+
+// LockFileName is the name of the lockfile in the project directory
+const LockFileName = "zemm.lock"
+
+type LockPackage struct {
+	Name     string   `json:"name" yaml:"name"`
+	Version  string   `json:"version" yaml:"version"`
+	List     string   `json:"list" yaml:"list"`
+	Digest   string   `json:"digest" yaml:"digest"`
+	Provides []string `json:"provides,omitempty" yaml:"provides,omitempty"`
+	// Depends are the locked packages this package depends on
+	Depends []string `json:"depends,omitempty" yaml:"depends,omitempty"`
+}
+
+type Lock struct {
+	Lists    []string      `json:"lists" yaml:"lists"`
+	Packages []LockPackage `json:"packages" yaml:"packages"`
+}
+
+// ReadLock reads the lockfile of the project in dir, a missing lockfile
+// results in an empty Lock
+func ReadLock(dir string) (*Lock, error) {
+	l := &Lock{Lists: []string{}, Packages: []LockPackage{}}
+
+	fp := path.Join(dir, LockFileName)
+	if !common.FileExists(fp) {
+		return l, nil
+	}
+
+	if err := common.URLToStruct(fp, l); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Marshal returns the lockfile contents with the packages sorted by name
+func (l *Lock) Marshal() ([]byte, error) {
+	sort.Slice(l.Packages, func(i, j int) bool {
+		return l.Packages[i].Name < l.Packages[j].Name
+	})
+
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+// Write writes the lockfile into dir
+func (l *Lock) Write(dir string) error {
+	data, err := l.Marshal()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(dir, LockFileName), data, common.OS_USER_RW|common.OS_GROUP_R|common.OS_OTH_R)
+}
+
+// Package returns the locked package with the given name
+func (l *Lock) Package(name string) (LockPackage, bool) {
+	for _, p := range l.Packages {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return LockPackage{}, false
+}
+
+// Graph returns the dependency graph of the locked packages
+func (l *Lock) Graph() *pm.Graph {
+	g := &pm.Graph{Nodes: []string{}, Edges: make(map[string][]string)}
+	for _, p := range l.Packages {
+		g.Nodes = append(g.Nodes, p.Name)
+		g.Edges[p.Name] = p.Depends
+	}
+	sort.Strings(g.Nodes)
+
+	return g
+}
@@ -0,0 +1,117 @@
+package install
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/zemm-io/zemm/overlay"
+	"github.com/zemm-io/zemm/pm"
+)
+
+// Orphans returns the installed packages no explicitly installed package
+// needs, the packages in without are not considered explicit
+func Orphans(state *State, lock *Lock, without []string) []string {
+	skip := make(map[string]bool)
+	for _, name := range without {
+		skip[name] = true
+	}
+
+	roots := []string{}
+	for _, name := range state.Explicit() {
+		if !skip[name] {
+			roots = append(roots, name)
+		}
+	}
+
+	keep := lock.Graph().Reachable(roots)
+	result := []string{}
+	for _, lp := range lock.Packages {
+		if !keep[lp.Name] {
+			result = append(result, lp.Name)
+		}
+	}
+
+	return result
+}
+
+// Remove removes the packages names from "install" and uninstalls them with
+// all dependencies no other explicitly installed package needs. Packages that
+// are still needed stay installed as dependencies. Without names it removes
+// the orphaned dependencies only. On dryRun nothing gets changed.
+func (i *Installer) Remove(names []string, dryRun bool) (*Result, error) {
+	dir := i.Project.Dir()
+
+	state, err := ReadState(dir)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := ReadLock(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if _, ok := lock.Package(name); !ok {
+			return nil, fmt.Errorf("Package \"%s\" is not installed", name)
+		}
+	}
+
+	result := &Result{
+		Packages:   []*pm.RPackage{},
+		Added:      []Change{},
+		Upgraded:   []Change{},
+		Downgraded: []Change{},
+		Updated:    []Change{},
+		Removed:    []Change{},
+		Overlaid:   []overlay.Change{},
+	}
+
+	removed := make(map[string]bool)
+	for _, name := range Orphans(state, lock, names) {
+		removed[name] = true
+	}
+
+	newLock := &Lock{Lists: lock.Lists, Packages: []LockPackage{}}
+	for _, lp := range lock.Packages {
+		if removed[lp.Name] {
+			result.Removed = append(result.Removed, Change{Name: lp.Name, OldVersion: lp.Version})
+			continue
+		}
+		newLock.Packages = append(newLock.Packages, lp)
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	snapshot, err := i.Project.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	err = func() error {
+		for _, name := range names {
+			if ip, ok := state.Package(name); ok && ip.Reason != ReasonExplicit {
+				continue
+			}
+			if err := i.Project.RemoveInstall(name); err != nil {
+				return err
+			}
+		}
+
+		current, err := CurrentGeneration(dir)
+		if err != nil {
+			return err
+		}
+		result, err = i.commit(result, newLock, current)
+		return err
+	}()
+	if err != nil {
+		if rErr := snapshot.Restore(); rErr != nil {
+			return nil, multierror.Append(err, rErr)
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
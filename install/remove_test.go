@@ -0,0 +1,209 @@
+package install
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mholt/archiver/v3"
+	"github.com/zemm-io/zemm/pkg"
+	"github.com/zemm-io/zemm/pm"
+	"github.com/zemm-io/zemm/project"
+	"gopkg.in/yaml.v2"
+)
+
+// newTestIndex creates an index with the list test/suite/1.0.0, test/app
+// depends on test/lib, test/tool has no dependencies and recommends test/extra
+func newTestIndex(t *testing.T) string {
+	index, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := `info:
+  name: test
+  version: 1.0.0
+packages:
+  - name: test/app
+    version: "1.0.0"
+    type: app
+    dependencies:
+      - package: test/lib
+  - name: test/lib
+    version: "1.0.0"
+  - name: test/tool
+    version: "1.0.0"
+    recommends:
+      - package: test/extra
+  - name: test/extra
+    version: "1.0.0"
+`
+	if err := os.MkdirAll(path.Join(index, "lists", "test", "suite"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(index, "lists", "test", "suite", "1.0.0.yaml"), []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &pm.Repository{}
+	if err := yaml.Unmarshal([]byte(list), r); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range r.Packages {
+		src := path.Join(index, "src", e.Name)
+		if err := os.MkdirAll(src, 0755); err != nil {
+			t.Fatal(err)
+		}
+		contents, err := yaml.Marshal(&pkg.Pkg{Info: pkg.FromListEntry(e)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := path.Join(src, pkg.FileName)
+		if err := ioutil.WriteFile(f, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := archiver.NewTarXz().Archive([]string{f}, path.Join(index, "packages", e.Name, e.Version+".txz")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return index
+}
+
+func installTestIndex(t *testing.T, index string, packages ...string) string {
+	dir, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := fmt.Sprintf("version: 1.0\nindexes:\n  zemm: %s\nlists:\n  main: test/suite/1.0.0\ninstall:\n", index)
+	for _, p := range packages {
+		content += fmt.Sprintf("  - package: %s\n", p)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, project.FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewInstaller(p).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func removeNames(t *testing.T, dir string, dryRun bool, names ...string) []string {
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := NewInstaller(p).Remove(names, dryRun)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed := []string{}
+	for _, c := range result.Removed {
+		removed = append(removed, c.Name)
+	}
+	return removed
+}
+
+func TestRemove(t *testing.T) {
+	index := newTestIndex(t)
+	defer os.RemoveAll(index)
+	dir := installTestIndex(t, index, "test/app", "test/tool")
+	defer os.RemoveAll(dir)
+
+	if removed := removeNames(t, dir, true, "test/app"); fmt.Sprint(removed) != "[test/app test/lib]" {
+		t.Error(fmt.Errorf("Dry run should remove test/app and test/lib, got %v", removed))
+	}
+	if lock, _ := ReadLock(dir); len(lock.Packages) != 4 {
+		t.Error(fmt.Errorf("Dry run changed the lock: %v", lock.Packages))
+	}
+
+	if removed := removeNames(t, dir, false, "test/app"); fmt.Sprint(removed) != "[test/app test/lib]" {
+		t.Error(fmt.Errorf("Remove should remove test/app and test/lib, got %v", removed))
+	}
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(p.InstallNames()) != "[test/tool]" {
+		t.Error(fmt.Errorf("test/app is still in %s: %v", project.FileName, p.InstallNames()))
+	}
+	state, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state.Package("test/app"); ok || len(state.Packages) != 2 {
+		t.Error(fmt.Errorf("Invalid state after remove: %v", state.Packages))
+	}
+}
+
+func TestAutoremove(t *testing.T) {
+	index := newTestIndex(t)
+	defer os.RemoveAll(index)
+	dir := installTestIndex(t, index, "test/app", "test/lib")
+	defer os.RemoveAll(dir)
+
+	// test/app still needs test/lib, it becomes a dependency
+	if removed := removeNames(t, dir, false, "test/lib"); len(removed) != 0 {
+		t.Error(fmt.Errorf("test/lib is still required, got %v", removed))
+	}
+	state, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip, _ := state.Package("test/lib"); ip.Reason != ReasonDependency {
+		t.Error(fmt.Errorf("test/lib should be a dependency now: %v", ip))
+	}
+
+	if removed := removeNames(t, dir, false); len(removed) != 0 {
+		t.Error(fmt.Errorf("Nothing is orphaned, got %v", removed))
+	}
+
+	// Without test/app in the state test/lib is an orphan
+	state.Packages = []InstalledPackage{{Name: "test/tool", Reason: ReasonExplicit}}
+	if err := state.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	if removed := removeNames(t, dir, true); fmt.Sprint(removed) != "[test/app test/lib]" {
+		t.Error(fmt.Errorf("Autoremove should remove the orphans, got %v", removed))
+	}
+}
+
+func TestRemoveKeepsReason(t *testing.T) {
+	index := newTestIndex(t)
+	defer os.RemoveAll(index)
+	dir := installTestIndex(t, index, "test/app", "test/tool")
+	defer os.RemoveAll(dir)
+
+	if removed := removeNames(t, dir, false, "test/app"); fmt.Sprint(removed) != "[test/app test/lib]" {
+		t.Error(fmt.Errorf("Remove should remove test/app and test/lib, got %v", removed))
+	}
+	state, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip, _ := state.Package("test/extra"); ip.Reason != ReasonRecommended {
+		t.Error(fmt.Errorf("test/extra should still be recommended: %v", ip))
+	}
+
+	if removed := removeNames(t, dir, false); len(removed) != 0 {
+		t.Error(fmt.Errorf("Nothing is orphaned, got %v", removed))
+	}
+	state, err = ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip, _ := state.Package("test/extra"); ip.Reason != ReasonRecommended {
+		t.Error(fmt.Errorf("test/extra should still be recommended after autoremove: %v", ip))
+	}
+}
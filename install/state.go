@@ -0,0 +1,263 @@
+package install
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/overlay"
+	"github.com/zemm-io/zemm/pm"
+)
+
+const (
+	// InstalledDir holds the installed state, relative to StateDir
+	InstalledDir = "state"
+	// InstalledFileName is the file with the installed state
+	InstalledFileName = "installed.json"
+)
+
+const (
+	// ReasonExplicit marks packages in "install" of the zemm files
+	ReasonExplicit = "explicit"
+	// ReasonDependency marks packages installed because others need them
+	ReasonDependency = "dependency"
+	// ReasonRecommended marks packages installed only because others recommend them
+	ReasonRecommended = "recommended"
+)
+
+// InstalledPackage is the record of an installed package
+type InstalledPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	List    string `json:"list"`
+	// ListIsDependency is set if the list got pulled in by the "depends" of another list
+	ListIsDependency bool      `json:"list_is_dependency,omitempty"`
+	Reason           string    `json:"reason"`
+	Type             string    `json:"type,omitempty"`
+	Provides         []string  `json:"provides,omitempty"`
+	Depends          []string  `json:"depends,omitempty"`
+	Overlay          bool      `json:"overlay,omitempty"`
+	Digest           string    `json:"digest"`
+	Installed        time.Time `json:"installed"`
+	// Files are the files of the package directory, relative to it
+	Files []string `json:"files"`
+	// Overlaid are the files of other packages this package changed
+	Overlaid []overlay.Change `json:"overlaid,omitempty"`
+}
+
+// State records what is installed in the project
+type State struct {
+	Generation int                `json:"generation"`
+	Updated    time.Time          `json:"updated"`
+	Packages   []InstalledPackage `json:"packages"`
+}
+
+// StatePath returns the location of the state file of the project in projectDir
+func StatePath(projectDir string) string {
+	return path.Join(projectDir, StateDir, InstalledDir, InstalledFileName)
+}
+
+// ReadState reads the installed state of the project in projectDir, nothing
+// installed yet results in an empty State
+func ReadState(projectDir string) (*State, error) {
+	return readStateFile(StatePath(projectDir))
+}
+
+func readStateFile(file string) (*State, error) {
+	s := &State{Packages: []InstalledPackage{}}
+	if !common.FileExists(file) {
+		return s, nil
+	}
+
+	if err := common.URLToStruct(file, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// writeFile writes the state atomically to file
+func (s *State) writeFile(file string) error {
+	sort.Slice(s.Packages, func(i, j int) bool {
+		return s.Packages[i].Name < s.Packages[j].Name
+	})
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(file), os.ModeDir|(common.OS_USER_RWX|common.OS_GROUP_RX|common.OS_OTH_RX)); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), common.OS_USER_RW|common.OS_GROUP_R|common.OS_OTH_R); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+// Write saves the state of the project in projectDir
+func (s *State) Write(projectDir string) error {
+	return s.writeFile(StatePath(projectDir))
+}
+
+// Package returns the installed package name
+func (s *State) Package(name string) (InstalledPackage, bool) {
+	for _, p := range s.Packages {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return InstalledPackage{}, false
+}
+
+// Explicit returns the names of the explicitly installed packages
+func (s *State) Explicit() []string {
+	result := []string{}
+	for _, p := range s.Packages {
+		if p.Reason == ReasonExplicit {
+			result = append(result, p.Name)
+		}
+	}
+
+	return result
+}
+
+// Owner returns the package that owns file, file is relative to the packages
+// directory like "library/nats/zemmpkg/compose.yaml". Files replaced by an
+// overlay belong to the overlay package.
+func (s *State) Owner(file string) (string, bool) {
+	file = path.Clean(file)
+
+	for _, p := range s.Packages {
+		for _, c := range p.Overlaid {
+			if !c.Patched && path.Join(c.Package, c.File) == file {
+				return p.Name, true
+			}
+		}
+	}
+
+	for _, p := range s.Packages {
+		if !strings.HasPrefix(file, p.Name+"/") {
+			continue
+		}
+		rel := strings.TrimPrefix(file, p.Name+"/")
+		for _, f := range p.Files {
+			if f == rel {
+				return p.Name, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// listFiles returns the files below dir relative to it
+func listFiles(dir string) ([]string, error) {
+	result := []string{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		result = append(result, filepath.ToSlash(rel))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+
+	return result, err
+}
+
+// newState creates the state of generation n from the result and lock of an
+// install, packagesDir holds the built packages
+func (i *Installer) newState(n int, result *Result, lock *Lock, packagesDir string, old *State) (*State, error) {
+	explicit := make(map[string]bool)
+	overlays := make(map[string]bool)
+	for _, inst := range i.Project.Install {
+		explicit[inst.Package] = true
+		overlays[inst.Package] = inst.Overlay
+	}
+
+	recommended := make(map[string]bool)
+	for _, name := range result.Recommended {
+		recommended[name] = true
+	}
+
+	repos := make(map[string]*pm.Repository)
+	types := make(map[string]string)
+	for _, p := range result.Packages {
+		repos[p.Name] = p.Repository
+		types[p.Name] = p.Kind()
+	}
+
+	now := time.Now()
+	s := &State{Generation: n, Updated: now, Packages: []InstalledPackage{}}
+	for _, lp := range lock.Packages {
+		ip := InstalledPackage{
+			Name:      lp.Name,
+			Version:   lp.Version,
+			List:      lp.List,
+			Reason:    ReasonDependency,
+			Provides:  lp.Provides,
+			Depends:   lp.Depends,
+			Overlay:   overlays[lp.Name],
+			Digest:    lp.Digest,
+			Installed: now,
+			Overlaid:  []overlay.Change{},
+		}
+		_, resolved := repos[lp.Name]
+		prev, known := old.Package(lp.Name)
+		if explicit[lp.Name] {
+			ip.Reason = ReasonExplicit
+		} else if recommended[lp.Name] {
+			ip.Reason = ReasonRecommended
+		} else if !resolved && known && prev.Reason != ReasonExplicit {
+			ip.Reason = prev.Reason
+		}
+		if r, ok := repos[lp.Name]; ok && r != nil {
+			ip.ListIsDependency = r.IsDependency()
+		} else if known {
+			ip.ListIsDependency = prev.ListIsDependency
+		}
+		if typ, ok := types[lp.Name]; ok {
+			ip.Type = typ
+		} else if known {
+			ip.Type = prev.Type
+		}
+		if known && prev.Version == lp.Version && prev.Digest == lp.Digest {
+			ip.Installed = prev.Installed
+		}
+		for _, c := range result.Overlaid {
+			if c.By == lp.Name {
+				ip.Overlaid = append(ip.Overlaid, c)
+			}
+		}
+
+		files, err := listFiles(path.Join(packagesDir, lp.Name))
+		if err != nil {
+			return nil, err
+		}
+		ip.Files = files
+
+		s.Packages = append(s.Packages, ip)
+	}
+
+	return s, nil
+}
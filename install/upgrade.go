@@ -0,0 +1,83 @@
+package install
+
+import (
+	"github.com/hashicorp/go-multierror"
+	"github.com/zemm-io/zemm/pm"
+	"github.com/zemm-io/zemm/project"
+)
+
+type ListUpgrade struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ListUpgrades returns the lists of the project for which a newer compatible
+// version is available on the index
+func (i *Installer) ListUpgrades() ([]ListUpgrade, error) {
+	index, err := i.Project.Index(project.DefaultIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ListUpgrade{}
+	for _, l := range i.Project.AllLists() {
+		newest, err := pm.NewestCompatibleList(index, l)
+		if err != nil {
+			return nil, err
+		}
+		if newest != l {
+			result = append(result, ListUpgrade{From: l, To: newest})
+		}
+	}
+
+	return result, nil
+}
+
+func (i *Installer) replaceLists(upgrades []ListUpgrade, reverse bool) {
+	for _, u := range upgrades {
+		if reverse {
+			i.Project.ReplaceList(u.To, u.From)
+		} else {
+			i.Project.ReplaceList(u.From, u.To)
+		}
+	}
+}
+
+// PlanUpgrade returns the package changes upgrading to the given lists would make
+func (i *Installer) PlanUpgrade(upgrades []ListUpgrade) (*Result, error) {
+	i.replaceLists(upgrades, false)
+	defer i.replaceLists(upgrades, true)
+
+	return i.Plan()
+}
+
+// Upgrade writes the new list versions to the zemm files and installs them,
+// on failure the zemm files are restored
+func (i *Installer) Upgrade(upgrades []ListUpgrade) (*Result, error) {
+	snapshot, err := i.Project.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	replacements := make(map[string]string)
+	for _, u := range upgrades {
+		replacements[u.From] = u.To
+	}
+
+	i.replaceLists(upgrades, false)
+	err = i.Project.WriteLists(replacements)
+
+	var result *Result
+	if err == nil {
+		result, err = i.Run()
+	}
+	if err != nil {
+		i.replaceLists(upgrades, true)
+		if rErr := snapshot.Restore(); rErr != nil {
+			return nil, multierror.Append(err, rErr)
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
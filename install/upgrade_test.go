@@ -0,0 +1,61 @@
+package install
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/zemm-io/zemm/project"
+)
+
+func TestUpgradeRollback(t *testing.T) {
+	dir := newTestProject(t, "tuatzemm/suite/1.0.0", "tuatzemm/sql_pgsql")
+	defer os.RemoveAll(dir)
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := NewInstaller(p)
+	i.Recommends = false
+
+	upgrades, err := i.ListUpgrades()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(upgrades) != 1 || upgrades[0].To != "tuatzemm/suite/1.0.1" {
+		t.Fatal(fmt.Errorf("Invalid upgrades: %v", upgrades))
+	}
+
+	plan, err := i.PlanUpgrade(upgrades)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Added) != 1 || plan.Added[0].Name != "tuatzemm/sql_pgsql" {
+		t.Error(fmt.Errorf("Invalid plan: %v", plan.Added))
+	}
+	if p.Lists.Main != "tuatzemm/suite/1.0.0" {
+		t.Error(fmt.Errorf("PlanUpgrade changed the main list to %s", p.Lists.Main))
+	}
+
+	// There are no archives for tuatzemm packages, so the upgrade must fail
+	// and restore zemm.yaml
+	if _, err := i.Upgrade(upgrades); err == nil {
+		t.Fatal(fmt.Errorf("Upgrade should fail without archives"))
+	}
+
+	contents, err := ioutil.ReadFile(path.Join(dir, project.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), "tuatzemm/suite/1.0.0") {
+		t.Error(fmt.Errorf("zemm.yaml has not been restored: %s", contents))
+	}
+	if p.Lists.Main != "tuatzemm/suite/1.0.0" {
+		t.Error(fmt.Errorf("Upgrade didn't restore the main list: %s", p.Lists.Main))
+	}
+}
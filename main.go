@@ -83,10 +83,20 @@ func main() {
 		pluginPaths = append(pluginPaths, matches...)
 	}
 
-	if len(pluginPaths) == 0 {
-		fmt.Printf("ERROR: No plugins found in %s\n", zemmPluginDirs)
-		os.Exit(1)
-	}
+	// Add builtin commands
+	rootCmd.AddCommand(newInstallCommand())
+	rootCmd.AddCommand(newListCommand())
+	rootCmd.AddCommand(newRemoveCommand())
+	rootCmd.AddCommand(newAutoremoveCommand())
+	rootCmd.AddCommand(newWhyCommand())
+	rootCmd.AddCommand(newWhyNotCommand())
+	rootCmd.AddCommand(newGraphCommand())
+	rootCmd.AddCommand(newUpdateCommand())
+	rootCmd.AddCommand(newUpgradeCommand())
+	rootCmd.AddCommand(newRollbackCommand())
+	rootCmd.AddCommand(newComposeCommand())
+	rootCmd.AddCommand(newServeCommand())
+	rootCmd.AddCommand(newPkgCommand())
 
 	// Add commands
 	for _, m := range pluginPaths {
@@ -95,6 +105,10 @@ func main() {
 			// Skip commands with a dash, those are subcommandss
 			continue
 		}
+		if c, _, err := rootCmd.Find([]string{e[5:]}); err == nil && c != rootCmd {
+			// Builtin commands win over plugins
+			continue
+		}
 
 		rootCmd.AddCommand(createDynamicCommand(m))
 	}
@@ -105,6 +119,8 @@ func main() {
 			os.Exit(exitError.ExitCode())
 		}
 
+		fmt.Printf("ERROR: %v\n", err)
+
 		os.Exit(144)
 	}
 }
@@ -0,0 +1,261 @@
+// Package overlay builds the file system view of a package with the files
+// of overlay packages layered on top.
+//
+// An overlay package ships its files in "zemmpkg/overlay/<namespace>/<package>/",
+// a file there replaces the file with the same path in the target package,
+// a file ending with ".patch" gets merged into the target file (without ".patch")
+// as JSON merge patch (RFC 7396), YAML files are patched the same way keeping
+// the order of their keys, comments get lost.
+package overlay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/otiai10/copy"
+	"github.com/zemm-io/zemm/common"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// Dir is the directory in an overlay package that contains the overlays
+	Dir = "zemmpkg/overlay"
+	// PatchSuffix marks files that get merged into the target file
+	PatchSuffix = ".patch"
+)
+
+// Layer is an overlay package and the directory it got extracted to
+type Layer struct {
+	Package string
+	Root    string
+}
+
+// Change records a file of a package that has been overlaid
+type Change struct {
+	Package string `json:"package"`
+	File    string `json:"file"`
+	By      string `json:"by"`
+	Patched bool   `json:"patched"`
+}
+
+// overlayFiles returns the files layer has for the target package, relative
+// to the target package
+func overlayFiles(layer Layer, target string) ([]string, error) {
+	base := path.Join(layer.Root, Dir, target)
+	result := []string{}
+
+	if !common.DirExists(base) {
+		return result, nil
+	}
+
+	err := filepath.Walk(base, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		result = append(result, rel)
+		return nil
+	})
+
+	sort.Strings(result)
+	return result, err
+}
+
+// Targets returns the names of all packages layer has overlays for
+func Targets(layer Layer) ([]string, error) {
+	base := path.Join(layer.Root, Dir)
+	result := []string{}
+
+	if !common.DirExists(base) {
+		return result, nil
+	}
+
+	namespaces, err := ioutil.ReadDir(base)
+	if err != nil {
+		return nil, err
+	}
+	for _, ns := range namespaces {
+		if !ns.IsDir() {
+			continue
+		}
+		pkgs, err := ioutil.ReadDir(path.Join(base, ns.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pkgs {
+			if p.IsDir() {
+				result = append(result, path.Join(ns.Name(), p.Name()))
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Build copies the package name from base to dst and applies the overlays
+// of all layers, two layers touching the same file is a conflict
+func Build(name, base, dst string, layers []Layer) ([]Change, error) {
+	result := &multierror.Error{}
+	changes := []Change{}
+
+	if err := os.RemoveAll(dst); err != nil {
+		return nil, err
+	}
+	if err := copy.Copy(base, dst); err != nil {
+		return nil, err
+	}
+
+	touched := make(map[string]string)
+	for _, l := range layers {
+		if l.Package == name {
+			continue
+		}
+
+		files, err := overlayFiles(l, name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			src := path.Join(l.Root, Dir, name, f)
+			target := f
+			patch := strings.HasSuffix(f, PatchSuffix)
+			if patch {
+				target = strings.TrimSuffix(f, PatchSuffix)
+			}
+
+			// A layer may replace a file and patch it, the copy comes first
+			if by, ok := touched[target]; ok && by != l.Package {
+				result = multierror.Append(result, fmt.Errorf("Conflict: file \"%s\" of package \"%s\" is overlaid by \"%s\" and \"%s\"", target, name, by, l.Package))
+				continue
+			}
+			touched[target] = l.Package
+
+			if patch {
+				err = patchFile(src, path.Join(dst, target))
+			} else {
+				err = common.CopyFile(src, path.Join(dst, target), true)
+			}
+			if err != nil {
+				result = multierror.Append(result, fmt.Errorf("Package \"%s\" failed to overlay \"%s\" of package \"%s\": %v", l.Package, target, name, err))
+				continue
+			}
+
+			changes = append(changes, Change{Package: name, File: target, By: l.Package, Patched: patch})
+		}
+	}
+
+	return changes, result.ErrorOrNil()
+}
+
+// patchFile merges the patch in src into the JSON or YAML file target
+func patchFile(src, target string) error {
+	patchData, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	targetData, err := ioutil.ReadFile(target)
+	if err != nil {
+		return err
+	}
+
+	var patch, doc interface{}
+	var out []byte
+
+	switch path.Ext(target) {
+	case ".json":
+		if err := json.Unmarshal(patchData, &patch); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(targetData, &doc); err != nil {
+			return err
+		}
+		out, err = json.MarshalIndent(MergePatch(doc, patch), "", "  ")
+	case ".yaml", ".yml":
+		yamlPatch, yamlDoc := yaml.MapSlice{}, yaml.MapSlice{}
+		if err := yaml.Unmarshal(patchData, &yamlPatch); err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(targetData, &yamlDoc); err != nil {
+			return err
+		}
+		out, err = yaml.Marshal(mergeYAML(yamlDoc, yamlPatch))
+	default:
+		return fmt.Errorf("Only JSON and YAML files can be patched")
+	}
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(target, out, common.OS_USER_RW|common.OS_GROUP_R|common.OS_OTH_R)
+}
+
+// MergePatch applies patch to target like RFC 7396 describes it
+func MergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = MergePatch(t[k], v)
+	}
+
+	return t
+}
+
+// mergeYAML applies patch to target like MergePatch but keeps the order of
+// the keys of target, new keys get appended
+func mergeYAML(target, patch interface{}) interface{} {
+	p, ok := patch.(yaml.MapSlice)
+	if !ok {
+		return patch
+	}
+
+	t, _ := target.(yaml.MapSlice)
+	result := append(yaml.MapSlice{}, t...)
+	for _, item := range p {
+		i := -1
+		for j := range result {
+			if fmt.Sprint(result[j].Key) == fmt.Sprint(item.Key) {
+				i = j
+				break
+			}
+		}
+
+		switch {
+		case item.Value == nil && i >= 0:
+			result = append(result[:i], result[i+1:]...)
+		case item.Value == nil:
+		case i >= 0:
+			result[i].Value = mergeYAML(result[i].Value, item.Value)
+		default:
+			result = append(result, yaml.MapItem{Key: item.Key, Value: mergeYAML(nil, item.Value)})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,122 @@
+package overlay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, base string, files map[string]string) {
+	for name, content := range files {
+		p := path.Join(base, name)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zemmoverlay-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, path.Join(dir, "library/nats"), map[string]string{
+		"conf/nats.conf":   "port: 4222",
+		"conf/config.yaml": "a: 1\nb:\n  c: 2\n  d: 3\n",
+		"conf/config.json": "{\"a\": 1, \"b\": {\"c\": 2}}",
+	})
+	writeFiles(t, path.Join(dir, "minadmin/app"), map[string]string{
+		"zemmpkg/overlay/library/nats/conf/nats.conf":         "port: 4333",
+		"zemmpkg/overlay/library/nats/conf/config.yaml.patch": "b:\n  c: 5\n  d: null\n",
+		"zemmpkg/overlay/library/nats/conf/config.json.patch": "{\"a\": null, \"e\": true}",
+	})
+
+	layers := []Layer{{Package: "minadmin/app", Root: path.Join(dir, "minadmin/app")}}
+	dst := path.Join(dir, "view/library/nats")
+
+	changes, err := Build("library/nats", path.Join(dir, "library/nats"), dst, layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Error(fmt.Errorf("Expected 3 changes, got: %v", changes))
+	}
+
+	for file, expected := range map[string]string{
+		"conf/nats.conf":   "port: 4333",
+		"conf/config.yaml": "a: 1\nb:\n  c: 5\n",
+		"conf/config.json": "{\n  \"b\": {\n    \"c\": 2\n  },\n  \"e\": true\n}",
+	} {
+		data, err := ioutil.ReadFile(path.Join(dst, file))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != expected {
+			t.Error(fmt.Errorf("Invalid content of %s: %q, expected %q", file, data, expected))
+		}
+	}
+
+	// The base must be untouched
+	data, _ := ioutil.ReadFile(path.Join(dir, "library/nats/conf/nats.conf"))
+	if string(data) != "port: 4222" {
+		t.Error(fmt.Errorf("Build changed the base directory"))
+	}
+
+	// A second overlay touching the same file is a conflict
+	writeFiles(t, path.Join(dir, "other/app"), map[string]string{
+		"zemmpkg/overlay/library/nats/conf/nats.conf": "port: 4444",
+	})
+	layers = append(layers, Layer{Package: "other/app", Root: path.Join(dir, "other/app")})
+	_, err = Build("library/nats", path.Join(dir, "library/nats"), dst, layers)
+	if err == nil || !strings.Contains(err.Error(), "Conflict") {
+		t.Error(fmt.Errorf("Conflict hasn't been detected: %v", err))
+	}
+}
+
+func TestBuildPatchKeepsOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zemmoverlay-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, path.Join(dir, "library/nats"), map[string]string{
+		"conf/config.yaml": "z: 1\na:\n  k: 2\n  b: 3\n",
+		"conf/other.yaml":  "z: 1\n",
+	})
+	// The layer replaces other.yaml and patches it too
+	writeFiles(t, path.Join(dir, "minadmin/app"), map[string]string{
+		"zemmpkg/overlay/library/nats/conf/config.yaml.patch": "a:\n  k: null\n  c: 4\nm: 5\n",
+		"zemmpkg/overlay/library/nats/conf/other.yaml":        "v: 2\nx: 3\n",
+		"zemmpkg/overlay/library/nats/conf/other.yaml.patch":  "w: 4\n",
+	})
+
+	layers := []Layer{{Package: "minadmin/app", Root: path.Join(dir, "minadmin/app")}}
+	dst := path.Join(dir, "view/library/nats")
+	if _, err := Build("library/nats", path.Join(dir, "library/nats"), dst, layers); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, expected := range map[string]string{
+		"conf/config.yaml": "z: 1\na:\n  b: 3\n  c: 4\nm: 5\n",
+		"conf/other.yaml":  "v: 2\nx: 3\nw: 4\n",
+	} {
+		data, err := ioutil.ReadFile(path.Join(dst, file))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != expected {
+			t.Error(fmt.Errorf("Invalid content of %s: %q, expected %q", file, data, expected))
+		}
+	}
+}
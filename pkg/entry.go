@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mholt/archiver/v3"
+	"github.com/zemm-io/zemm/events"
+	"github.com/zemm-io/zemm/pm"
+	"gopkg.in/yaml.v2"
+)
+
+// ListEntry returns the list entry describing the package
+func (p *Pkg) ListEntry() pm.RPackage {
+	return pm.RPackage{PackageInfo: p.Info}
+}
+
+// FromListEntry returns the package metadata of the list entry e
+func FromListEntry(e pm.RPackage) Info {
+	return e.PackageInfo
+}
+
+// ReadArchive reads the package description embedded in the package archive
+// and emits the package-read event to sink
+func ReadArchive(archive string, sink events.Sink) (*Pkg, error) {
+	var result *Pkg
+
+	err := archiver.NewTarXz().Walk(archive, func(f archiver.File) error {
+		h, ok := f.Header.(*tar.Header)
+		if !ok || path.Clean(h.Name) != FileName {
+			return nil
+		}
+
+		contents, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		result = &Pkg{}
+		if err := yaml.Unmarshal(contents, result); err != nil {
+			return fmt.Errorf("Failed to decode %s of \"%s\": %v", FileName, archive, err)
+		}
+		return archiver.ErrStopWalk
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, fmt.Errorf("Package archive \"%s\" contains no %s", archive, FileName)
+	}
+	result.SetEvents(sink)
+	sink.Emit(events.Event{Type: events.PackageRead, Package: result.Info.Name, Version: result.Info.Version, Message: archive})
+
+	return result, nil
+}
+
+func dependencyStrings(deps []InfoDep) []string {
+	result := []string{}
+	for _, d := range deps {
+		s := d.Package
+		if d.Version != "" {
+			s += " " + d.Version
+		}
+		if d.Default != "" {
+			s += " (default " + d.Default + ")"
+		}
+		result = append(result, s)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func sortedStrings(s []string) []string {
+	result := append([]string{}, s...)
+	sort.Strings(result)
+	return result
+}
+
+// MatchListEntry checks that the list entry e describes the package, the
+// version is only compared if both have one
+func (p *Pkg) MatchListEntry(e *pm.RPackage) error {
+	result := &multierror.Error{}
+
+	compare := func(field, list, pkg string) {
+		if list != pkg {
+			result = multierror.Append(result, fmt.Errorf("Package \"%s\": \"%s\" is \"%s\" in the list but \"%s\" in the package", e.Name, field, list, pkg))
+		}
+	}
+
+	compare("name", e.Name, p.Info.Name)
+	if e.Version != "" && p.Info.Version != "" {
+		compare("version", e.Version, p.Info.Version)
+	}
+	compare("type", e.Kind(), p.Info.Kind())
+	compare("extends", e.Extends, p.Info.Extends)
+	compare("provides", strings.Join(sortedStrings(e.Provides), ", "), strings.Join(sortedStrings(p.Info.Provides), ", "))
+	compare("dependencies", strings.Join(dependencyStrings(e.Dependencies), ", "), strings.Join(dependencyStrings(p.Info.Dependencies), ", "))
+	compare("recommends", strings.Join(dependencyStrings(e.Recommends), ", "), strings.Join(dependencyStrings(p.Info.Recommends), ", "))
+
+	return result.ErrorOrNil()
+}
@@ -11,28 +11,21 @@ import (
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/events"
+	"github.com/zemm-io/zemm/pm"
 
 	"github.com/mholt/archiver/v3"
 	"github.com/otiai10/copy"
 )
 
-type InfoDep struct {
-	Package string `json:"package" yaml:"package"`
-}
+// FileName is the name of the package description in the package directory and archive
+const FileName = "zemmpkg.yaml"
 
-type Info struct {
-	Name         string    `json:"name" yaml:"name"`
-	Version      string    `json:"version" yaml:"version"`
-	Description  string    `json:"description" yaml:"description"`
-	Author       string    `json:"author" yaml:"author"`
-	Packager     string    `json:"packager,omitempty" yaml:"packager,omitempty"`
-	License      string    `json:"license" yaml:"license"`
-	Homepage     string    `json:"homepage" yaml:"homepage"`
-	Repo         string    `json:"repo" yaml:"repo"`
-	Provides     []string  `json:"provides" yaml:"provides"`
-	Dependencies []InfoDep `json:"dependencies" yaml:"dependencies"`
-	Recommends   []InfoDep `json:"recommends" yaml:"recommends"`
-}
+// InfoDep is a dependency of a package
+type InfoDep = pm.RPDependency
+
+// Info is the metadata of a package, the same as in its list entry
+type Info = pm.PackageInfo
 
 type FileOrDir struct {
 	File      string `json:"file,omitempty" yaml:"file,omitempty"`
@@ -40,9 +33,16 @@ type FileOrDir struct {
 }
 
 type Pkg struct {
-	path  string
-	Info  Info        `json:"info" yaml:"info"`
-	Files []FileOrDir `json:"files" yaml:"files"`
+	path     string
+	events   events.Sink
+	Info     Info        `json:"info" yaml:"info"`
+	Files    []FileOrDir `json:"files" yaml:"files"`
+	Settings []Setting   `json:"settings,omitempty" yaml:"settings,omitempty"`
+}
+
+// SetEvents sets the sink for the package-built event
+func (p *Pkg) SetEvents(sink events.Sink) {
+	p.events = sink
 }
 
 func NewPkg(path string) (*Pkg, error) {
@@ -103,12 +103,33 @@ func (p *Pkg) Verify() error {
 		result = multierror.Append(result, errors.New("Name must contain a namespace, for example \"mynamespace/mypackage\""))
 	}
 
+	if err := pm.ValidateType(p.Info.Name, p.Info.Type, p.Info.Extends); err != nil {
+		result = multierror.Append(result, err)
+	}
+
 	p.verifyFiles(result)
 
+	for _, s := range p.Settings {
+		if err := s.Verify(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
 	return result.ErrorOrNil()
 }
 
-func (p *Pkg) MakePackage() (string, error) {
+// ArchiveName returns the file name of the package archive
+func (p *Pkg) ArchiveName() string {
+	if p.Info.Version == "" {
+		return fmt.Sprintf("%s.txz", p.Package())
+	}
+
+	return fmt.Sprintf("%s-%s.txz", p.Package(), p.Info.Version)
+}
+
+// MakePackage builds the package archive in outDir and returns its path, the
+// package description is stored as zemmpkg.yaml whatever its name is
+func (p *Pkg) MakePackage(outDir string) (string, error) {
 	basePath, err := filepath.Abs(path.Dir(p.path))
 	if err != nil {
 		return "", err
@@ -120,74 +141,47 @@ func (p *Pkg) MakePackage() (string, error) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	// Copy zemmpkg.yaml
-	if err = common.CopyFile(path.Join(basePath, "zemmpkg.yaml"), path.Join(tmpDir, "zemmpkg.yaml"), true); err != nil {
+	// Copy the package description
+	if err = common.CopyFile(p.path, path.Join(tmpDir, FileName), true); err != nil {
 		return "", err
 	}
 
 	// Copy files and directories
-	paths := []string{"zemmpkg.yaml"}
 	for _, fod := range p.Files {
-		if fod.File != "" {
-			// Copy the file to the tmp path
-			if err = copy.Copy(path.Join(basePath, fod.File), path.Join(tmpDir, fod.File)); err != nil {
-				return "", err
-			}
-
-			// Add each directory
-			if strings.Contains(fod.File, "/") {
-				exp := strings.Split(path.Dir(fod.File), "/")
-				for i := range exp {
-					if i == 0 {
-						paths = append(paths, exp[0])
-					} else {
-						paths = append(paths, path.Join(exp[0:i]...))
-					}
-				}
-			}
-			paths = append(paths, fod.File)
-		} else if fod.Directory != "" {
-			// Copy the directory to the tmp path
-			if err = copy.Copy(path.Join(basePath, fod.Directory), path.Join(tmpDir, fod.Directory)); err != nil {
-				return "", err
-			}
-
-			// Add each directory
-			if strings.Contains(fod.Directory, "/") {
-				exp := strings.Split(fod.Directory, "/")
-				for i := range exp {
-					if i > 0 && len(exp[i]) > 0 {
-						paths = append(paths, path.Join(exp[0:i]...))
-					}
-				}
-			}
-			paths = append(paths, fod.Directory)
+		src := fod.File
+		if src == "" {
+			src = fod.Directory
+		}
+		if src == "" {
+			continue
+		}
+		if err = copy.Copy(path.Join(basePath, src), path.Join(tmpDir, src)); err != nil {
+			return "", err
 		}
 	}
 
-	// Create archive name
-	outDir, err := ioutil.TempDir("", "zemmout-")
-	if err != nil {
-		return "", err
-	}
-	// defer os.RemoveAll(outDir)
-
-	pwd, err := os.Getwd()
+	// Archive the top level entries so the paths in the archive are relative
+	entries, err := ioutil.ReadDir(tmpDir)
 	if err != nil {
 		return "", err
 	}
-	if err = os.Chdir(tmpDir); err != nil {
-		return "", err
+	sources := []string{}
+	for _, e := range entries {
+		sources = append(sources, path.Join(tmpDir, e.Name()))
 	}
-	archFilePath := path.Join(outDir, fmt.Sprintf("%s-%s.txz", p.Package(), p.Info.Version))
 
-	if err = archiver.Archive([]string{"."}, archFilePath); err != nil {
+	if err := os.MkdirAll(outDir, os.ModeDir|(common.OS_USER_RWX|common.OS_GROUP_RX|common.OS_OTH_RX)); err != nil {
 		return "", err
 	}
+	archFilePath := path.Join(outDir, p.ArchiveName())
 
-	if err = os.Chdir(pwd); err != nil {
+	tx := archiver.NewTarXz()
+	tx.OverwriteExisting = true
+	if err = tx.Archive(sources, archFilePath); err != nil {
+		os.Remove(archFilePath)
 		return "", err
 	}
+	p.events.Emit(events.Event{Type: events.PackageBuilt, Package: p.Info.Name, Version: p.Info.Version, Message: archFilePath})
 
 	return archFilePath, nil
 }
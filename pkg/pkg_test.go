@@ -2,7 +2,15 @@ package pkg
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
 	"testing"
+
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/events"
+	"github.com/zemm-io/zemm/pm"
 )
 
 func TestZemmPkgNoUrl(t *testing.T) {
@@ -32,6 +40,14 @@ func TestVerifyZemmPkg(t *testing.T) {
 	}
 }
 
+func tempDirs(t *testing.T) int {
+	matches, err := filepath.Glob(filepath.Join(os.TempDir(), "zemmpkg-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(matches)
+}
+
 func TestMakePackage(t *testing.T) {
 	p, err := NewPkg("../examples/apps/minadmin/minadmin_pgsql/zemmpkg.yaml")
 	// p, err := NewPkg("../examples/apps/library/nats/zemmpkg.yaml")
@@ -44,8 +60,201 @@ func TestMakePackage(t *testing.T) {
 		t.Error(err)
 	}
 
-	_, err = p.MakePackage()
+	outDir, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+
+	seen := []events.Event{}
+	sink := func(e events.Event) { seen = append(seen, e) }
+	p.SetEvents(sink)
+
+	before := tempDirs(t)
+	archive, err := p.MakePackage(path.Join(outDir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive != path.Join(outDir, "out", "minadmin_pgsql-1.0.0.txz") {
+		t.Error(fmt.Errorf("Invalid archive path: %s", archive))
+	}
+	if after := tempDirs(t); after != before {
+		t.Error(fmt.Errorf("MakePackage left %d temporary directories behind", after-before))
+	}
+
+	built, err := ReadArchive(archive, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.Info.Name != p.Info.Name {
+		t.Error(fmt.Errorf("Invalid package in the archive: %v", built.Info))
+	}
+	if len(seen) != 2 || seen[0].Type != events.PackageBuilt || seen[1].Type != events.PackageRead || seen[0].Message != archive {
+		t.Error(fmt.Errorf("Invalid events: %v", seen))
+	}
+
+	// Building again replaces the archive
+	if _, err := p.MakePackage(path.Join(outDir, "out")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMakePackageCustomName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := common.CopyFile("../examples/apps/library/nats/zemmpkg.yaml", path.Join(dir, "nats.yaml"), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := common.CopyFile("../examples/apps/library/nats/zemmpkg/compose.yaml", path.Join(dir, "zemmpkg", "compose.yaml"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPkg(path.Join(dir, "nats.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := p.MakePackage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The description is always stored as zemmpkg.yaml
+	if built, err := ReadArchive(archive, nil); err != nil || built.Info.Name != "library/nats" {
+		t.Error(fmt.Errorf("Invalid archive %s: %v", archive, err))
+	}
+}
+
+func TestSettingValue(t *testing.T) {
+	port := Setting{Name: "PORT", Type: "port", Default: 4222}
+	if v, err := port.Value(nil); err != nil || v != "4222" {
+		t.Error(fmt.Errorf("Expected the default 4222, got %s (%v)", v, err))
+	}
+	if _, err := port.Value(99999); err == nil {
+		t.Error(fmt.Errorf("99999 shouldn't be a valid port"))
+	}
+
+	required := Setting{Name: "PASSWORD", Required: true, Secret: true}
+	if _, err := required.Value(nil); err == nil {
+		t.Error(fmt.Errorf("Missing required setting hasn't been detected"))
+	}
+
+	if err := (Setting{Name: "X", Type: "float"}).Verify(); err == nil {
+		t.Error(fmt.Errorf("Unknown type hasn't been detected"))
+	}
+	if err := (Setting{Name: "X", Type: "bool", Default: "maybe"}).Verify(); err == nil {
+		t.Error(fmt.Errorf("Invalid default hasn't been detected"))
+	}
+	if err := (Setting{Name: "X", Port: 4222}).Verify(); err == nil {
+		t.Error(fmt.Errorf("Published string setting hasn't been detected"))
+	}
+	if err := (Setting{Name: "X", Volume: "data"}).Verify(); err == nil {
+		t.Error(fmt.Errorf("Relative volume path hasn't been detected"))
+	}
+
+	published := Setting{Name: "PORT", Type: "port", Port: 4222}
+	if key, mapping := published.Mapping("4333"); key != "ports" || mapping != "4333:4222" {
+		t.Error(fmt.Errorf("Invalid mapping %s: %s", key, mapping))
+	}
+}
+
+func TestMatchListEntry(t *testing.T) {
+	p, err := NewPkg("../examples/apps/minadmin/minadmin_pgsql/zemmpkg.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := pm.NewRepository("../examples/repo/", "minadmin/minadmin/1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entry *pm.RPackage
+	for i := range r.Packages {
+		if r.Packages[i].Name == p.Info.Name {
+			entry = &r.Packages[i]
+		}
+	}
+	if entry == nil {
+		t.Fatal(fmt.Errorf("Package %s is not in the list", p.Info.Name))
+	}
+
+	if err := p.MatchListEntry(entry); err != nil {
+		t.Error(err)
+	}
+	if converted := p.ListEntry(); FromListEntry(converted).Name != p.Info.Name {
+		t.Error(fmt.Errorf("Invalid conversion: %v", converted))
+	}
+
+	entry.Type = pm.TypeService
+	entry.Dependencies = entry.Dependencies[1:]
+	if err := p.MatchListEntry(entry); err == nil {
+		t.Error(fmt.Errorf("A list entry with another type and dependencies should not match"))
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	p, err := ReadArchive("../examples/repo/packages/library/nats/2.1.9.txz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Info.Name != "library/nats" || p.Info.Version != "2.1.9" {
+		t.Error(fmt.Errorf("Invalid package info: %v", p.Info))
+	}
+
+	r, err := pm.NewRepository("../examples/repo/", "library/nats/2.1.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.MatchListEntry(&r.Packages[0]); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyPlugin(t *testing.T) {
+	p, err := NewPkg("../examples/apps/minadmin/agent_ntnx_pqsql/zemmpkg.yaml")
 	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(); err != nil {
 		t.Error(err)
 	}
+
+	p.Info.Extends = ""
+	if err := p.Verify(); err == nil {
+		t.Error(fmt.Errorf("A plugin without extends should not verify"))
+	}
+}
+
+func TestExampleArchives(t *testing.T) {
+	archives := map[string]string{
+		"../examples/repo/lists/library/nats/2.1.9.yaml":      "../examples/repo/packages/library/nats/2.1.9.txz",
+		"../examples/repo/lists/minadmin/minadmin/1.0.0.yaml": "../examples/repo/packages/minadmin/minadmin_pgsql/1.0.0.txz",
+	}
+	for list, archive := range archives {
+		r := &pm.Repository{}
+		if err := common.URLToStruct(list, r); err != nil {
+			t.Fatal(err)
+		}
+		p, err := ReadArchive(archive, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		found := false
+		for i := range r.Packages {
+			if r.Packages[i].Name == p.Info.Name {
+				found = true
+				if err := p.MatchListEntry(&r.Packages[i]); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+		if !found {
+			t.Error(fmt.Errorf("Package \"%s\" of %s is not in %s", p.Info.Name, archive, list))
+		}
+	}
 }
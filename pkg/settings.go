@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+)
+
+// SettingTypes are the types a setting can have
+var SettingTypes = []string{"string", "int", "bool", "port"}
+
+// Setting is a setting a package declares, users set its value in the
+// "settings" of their zemm files
+type Setting struct {
+	Name        string      `json:"name" yaml:"name"`
+	Type        string      `json:"type,omitempty" yaml:"type,omitempty"`
+	Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+	Default     interface{} `json:"default,omitempty" yaml:"default,omitempty"`
+	Required    bool        `json:"required,omitempty" yaml:"required,omitempty"`
+	Secret      bool        `json:"secret,omitempty" yaml:"secret,omitempty"`
+	// Port is the container port a port setting gets published on
+	Port int `json:"port,omitempty" yaml:"port,omitempty"`
+	// Volume is the container path the host path of a string setting gets mounted on
+	Volume string `json:"volume,omitempty" yaml:"volume,omitempty"`
+}
+
+// Verify checks the declaration of the setting
+func (s Setting) Verify() error {
+	if s.Name == "" {
+		return fmt.Errorf("Settings need a name")
+	}
+
+	known := s.Type == ""
+	for _, t := range SettingTypes {
+		if s.Type == t {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("Setting \"%s\" has an unknown type \"%s\", must be one of %v", s.Name, s.Type, SettingTypes)
+	}
+
+	if s.Port != 0 {
+		if s.Type != "port" {
+			return fmt.Errorf("Setting \"%s\" needs the type port to be published on a port", s.Name)
+		}
+		if s.Port < 1 || s.Port > 65535 {
+			return fmt.Errorf("Setting \"%s\" has an invalid port %d, must be 1-65535", s.Name, s.Port)
+		}
+	}
+	if s.Volume != "" {
+		if s.Type != "" && s.Type != "string" {
+			return fmt.Errorf("Setting \"%s\" needs the type string to be mounted as volume", s.Name)
+		}
+		if !path.IsAbs(s.Volume) {
+			return fmt.Errorf("Setting \"%s\" has a relative volume path \"%s\"", s.Name, s.Volume)
+		}
+	}
+
+	if s.Default != nil {
+		if _, err := s.Value(s.Default); err != nil {
+			return fmt.Errorf("Invalid default: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Value checks value against the type of the setting and returns it as string,
+// a nil value results in the default, it's an error if there is none for a
+// required setting
+func (s Setting) Value(value interface{}) (string, error) {
+	if value == nil {
+		value = s.Default
+	}
+	if value == nil {
+		if s.Required {
+			return "", fmt.Errorf("Setting \"%s\" is required", s.Name)
+		}
+		return "", nil
+	}
+
+	str := fmt.Sprintf("%v", value)
+
+	switch s.Type {
+	case "int":
+		if _, err := strconv.Atoi(str); err != nil {
+			return "", fmt.Errorf("Setting \"%s\" must be an int, got \"%s\"", s.Name, str)
+		}
+	case "bool":
+		if _, err := strconv.ParseBool(str); err != nil {
+			return "", fmt.Errorf("Setting \"%s\" must be a bool, got \"%s\"", s.Name, str)
+		}
+	case "port":
+		port, err := strconv.Atoi(str)
+		if err != nil || port < 1 || port > 65535 {
+			return "", fmt.Errorf("Setting \"%s\" must be a port (1-65535), got \"%s\"", s.Name, str)
+		}
+	}
+
+	return str, nil
+}
+
+// Mapping returns the "ports" or "volumes" entry of the setting with the
+// given value, it is empty if the setting is not published or mounted
+func (s Setting) Mapping(value string) (string, string) {
+	switch {
+	case value == "":
+		return "", ""
+	case s.Port != 0:
+		return "ports", fmt.Sprintf("%s:%d", value, s.Port)
+	case s.Volume != "":
+		return "volumes", fmt.Sprintf("%s:%s", value, s.Volume)
+	}
+	return "", ""
+}
@@ -0,0 +1,252 @@
+package pm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	EdgeDepends    = "depends"
+	EdgeRecommends = "recommends"
+	// EdgeDefault points to the default provider picked for a virtual package
+	EdgeDefault = "default"
+	// EdgeProvides points from a package to the virtual package it provides
+	EdgeProvides = "provides"
+)
+
+// GraphFormats are the formats ResolvedGraph renders to
+var GraphFormats = []string{"dot", "mermaid", "json"}
+
+type GraphNode struct {
+	Name     string `json:"name"`
+	Version  string `json:"version,omitempty"`
+	List     string `json:"list,omitempty"`
+	Virtual  bool   `json:"virtual,omitempty"`
+	Explicit bool   `json:"explicit,omitempty"`
+}
+
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+	// Via is the virtual package the dependency was on
+	Via string `json:"via,omitempty"`
+}
+
+// ResolvedGraph is the result of the last GetDependencies with the kind of
+// every edge, for export
+type ResolvedGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// ResolvedGraph returns the graph of the packages the last GetDependencies selected
+func (pm *PackageManager) ResolvedGraph() *ResolvedGraph {
+	g := &ResolvedGraph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	nodes := make(map[string]int)
+	edges := make(map[GraphEdge]bool)
+
+	addEdge := func(e GraphEdge) {
+		if e.From != e.To && !edges[e] {
+			edges[e] = true
+			g.Edges = append(g.Edges, e)
+		}
+	}
+	kind := func(d Decision) string {
+		if d.Recommends {
+			return EdgeRecommends
+		}
+		return EdgeDepends
+	}
+
+	for _, d := range pm.trace {
+		if !d.Selected() {
+			continue
+		}
+		if _, ok := nodes[d.Package]; ok {
+			continue
+		}
+
+		n := GraphNode{Name: d.Package, Explicit: d.Kind == DecisionExplicit}
+		if p, ok := pm.packages[d.Package]; ok {
+			n.Version = p.Version
+			if p.Repository != nil {
+				n.List = p.Repository.GetList()
+			}
+			for _, prov := range p.ProvidedNames() {
+				addEdge(GraphEdge{From: p.Name, To: prov, Kind: EdgeProvides})
+			}
+		}
+		nodes[n.Name] = len(g.Nodes)
+		g.Nodes = append(g.Nodes, n)
+	}
+
+	for _, d := range pm.trace {
+		switch d.Kind {
+		case DecisionDependency:
+			addEdge(GraphEdge{From: d.By, To: d.Package, Kind: kind(d)})
+		case DecisionDefault, DecisionProvider:
+			addEdge(GraphEdge{From: d.By, To: d.Package, Kind: EdgeDefault, Via: d.Dependency})
+		case DecisionSatisfied:
+			if d.Provider == "" {
+				continue
+			}
+			e := GraphEdge{From: d.By, To: d.Provider, Kind: kind(d)}
+			if d.Dependency != d.Provider {
+				e.Via = d.Dependency
+			}
+			addEdge(e)
+		}
+	}
+
+	// Virtual packages are the targets of provides edges
+	for _, e := range g.Edges {
+		if _, ok := nodes[e.To]; !ok && e.Kind == EdgeProvides {
+			nodes[e.To] = len(g.Nodes)
+			g.Nodes = append(g.Nodes, GraphNode{Name: e.To, Virtual: true})
+		}
+	}
+
+	g.sort()
+	return g
+}
+
+func (g *ResolvedGraph) sort() {
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].Name < g.Nodes[j].Name
+	})
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+}
+
+// namespace returns the namespace of the package name
+func namespace(name string) string {
+	return strings.SplitN(name, "/", 2)[0]
+}
+
+// Collapse returns the graph with a node per namespace, edges inside a
+// namespace are dropped
+func (g *ResolvedGraph) Collapse() *ResolvedGraph {
+	result := &ResolvedGraph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+
+	nodes := make(map[string]bool)
+	for _, n := range g.Nodes {
+		ns := namespace(n.Name)
+		if !nodes[ns] {
+			nodes[ns] = true
+			result.Nodes = append(result.Nodes, GraphNode{Name: ns})
+		}
+	}
+
+	edges := make(map[GraphEdge]bool)
+	for _, e := range g.Edges {
+		c := GraphEdge{From: namespace(e.From), To: namespace(e.To), Kind: e.Kind}
+		if c.Kind == EdgeDefault {
+			c.Kind = EdgeDepends
+		}
+		if c.From == c.To || edges[c] {
+			continue
+		}
+		edges[c] = true
+		result.Edges = append(result.Edges, c)
+	}
+
+	result.sort()
+	return result
+}
+
+// DOT renders the graph in the Graphviz DOT language
+func (g *ResolvedGraph) DOT() string {
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "digraph zemm {")
+	fmt.Fprintln(b, "  rankdir=LR;")
+	fmt.Fprintln(b, "  node [shape=box];")
+
+	for _, n := range g.Nodes {
+		label := n.Name
+		if n.Version != "" {
+			label += "\\n" + n.Version
+		}
+		attrs := []string{fmt.Sprintf("label=%q", label)}
+		if n.List != "" {
+			attrs = append(attrs, fmt.Sprintf("tooltip=%q", n.List))
+		}
+		if n.Virtual {
+			attrs = append(attrs, "shape=ellipse", "style=dashed")
+		}
+		if n.Explicit {
+			attrs = append(attrs, "penwidth=2")
+		}
+		fmt.Fprintf(b, "  %q [%s];\n", n.Name, strings.Join(attrs, ", "))
+	}
+
+	for _, e := range g.Edges {
+		attrs := []string{}
+		switch e.Kind {
+		case EdgeRecommends:
+			attrs = append(attrs, "style=dashed")
+		case EdgeDefault:
+			attrs = append(attrs, "style=bold")
+		case EdgeProvides:
+			attrs = append(attrs, "style=dotted", "arrowhead=empty")
+		}
+		if e.Via != "" {
+			attrs = append(attrs, fmt.Sprintf("label=%q", e.Via))
+		}
+		fmt.Fprintf(b, "  %q -> %q", e.From, e.To)
+		if len(attrs) > 0 {
+			fmt.Fprintf(b, " [%s]", strings.Join(attrs, ", "))
+		}
+		fmt.Fprintln(b, ";")
+	}
+
+	fmt.Fprintln(b, "}")
+	return b.String()
+}
+
+// Mermaid renders the graph as Mermaid flowchart
+func (g *ResolvedGraph) Mermaid() string {
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "graph LR")
+
+	ids := make(map[string]string)
+	for i, n := range g.Nodes {
+		ids[n.Name] = fmt.Sprintf("n%d", i)
+		label := n.Name
+		if n.Version != "" {
+			label += "<br/>" + n.Version
+		}
+		if n.Virtual {
+			fmt.Fprintf(b, "  %s([\"%s\"])\n", ids[n.Name], label)
+		} else {
+			fmt.Fprintf(b, "  %s[\"%s\"]\n", ids[n.Name], label)
+		}
+	}
+
+	for _, e := range g.Edges {
+		arrow := "-->"
+		switch e.Kind {
+		case EdgeRecommends:
+			arrow = "-.->"
+		case EdgeDefault:
+			arrow = "==>"
+		case EdgeProvides:
+			arrow = "-.-o"
+		}
+		if e.Via != "" {
+			arrow += "|" + e.Via + "|"
+		}
+		fmt.Fprintf(b, "  %s %s %s\n", ids[e.From], arrow, ids[e.To])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,130 @@
+package pm
+
+import (
+	"sort"
+)
+
+// Graph is the dependency graph of a set of resolved packages
+type Graph struct {
+	Nodes []string
+	// Edges maps a package to the packages it depends on
+	Edges map[string][]string
+}
+
+// NewGraph creates the graph of the resolved packages pkgs, dependencies
+// on virtual packages point to the package in pkgs that provides them
+func NewGraph(pkgs []*RPackage, recommends bool) *Graph {
+	g := &Graph{Nodes: []string{}, Edges: make(map[string][]string)}
+
+	names := make(map[string]string)
+	for _, p := range pkgs {
+		g.Nodes = append(g.Nodes, p.Name)
+		names[p.Name] = p.Name
+	}
+	for _, p := range pkgs {
+		for _, prov := range p.ProvidedNames() {
+			if _, ok := names[prov]; !ok {
+				names[prov] = p.Name
+			}
+		}
+	}
+	sort.Strings(g.Nodes)
+
+	for _, p := range pkgs {
+		all := append([]RPDependency{}, p.Dependencies...)
+		if recommends {
+			all = append(all, p.Recommends...)
+		}
+
+		seen := make(map[string]int)
+		for _, d := range all {
+			target, ok := names[d.Package]
+			if !ok || target == p.Name {
+				continue
+			}
+			if _, ok := seen[target]; ok {
+				continue
+			}
+			seen[target] = 0
+			g.Edges[p.Name] = append(g.Edges[p.Name], target)
+		}
+		sort.Strings(g.Edges[p.Name])
+	}
+
+	return g
+}
+
+// TopologicalOrder returns the packages with dependencies before the packages
+// depending on them, cycles get broken up in alphabetical order
+func (g *Graph) TopologicalOrder() []string {
+	result := []string{}
+	done := make(map[string]bool)
+
+	for len(result) < len(g.Nodes) {
+		progress := false
+		for _, n := range g.Nodes {
+			if done[n] {
+				continue
+			}
+
+			ready := true
+			for _, d := range g.Edges[n] {
+				if !done[d] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				result = append(result, n)
+				done[n] = true
+				progress = true
+			}
+		}
+
+		if !progress {
+			// Cycle, take the first package left
+			for _, n := range g.Nodes {
+				if !done[n] {
+					result = append(result, n)
+					done[n] = true
+					break
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// Reachable returns all packages reachable from roots including the roots
+func (g *Graph) Reachable(roots []string) map[string]bool {
+	result := make(map[string]bool)
+
+	todo := append([]string{}, roots...)
+	for len(todo) > 0 {
+		n := todo[0]
+		todo = todo[1:]
+		if result[n] {
+			continue
+		}
+		result[n] = true
+		todo = append(todo, g.Edges[n]...)
+	}
+
+	return result
+}
+
+// Dependents returns the packages that directly depend on name
+func (g *Graph) Dependents(name string) []string {
+	result := []string{}
+	for _, n := range g.Nodes {
+		for _, d := range g.Edges[n] {
+			if d == name {
+				result = append(result, n)
+				break
+			}
+		}
+	}
+
+	return result
+}
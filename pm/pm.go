@@ -5,12 +5,26 @@ import (
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/tpazderka/warning"
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/events"
 )
 
 type PackageManager struct {
 	repos     []*Repository
 	packages  map[string]*RPackage
 	providers map[string]map[string]*RPackage
+	events    events.Sink
+	trace     []Decision
+	without   map[string]bool
+	chosen    map[string]string
+	prefer    []string
+}
+
+// Request is a package to resolve, Recommends pulls in the recommended
+// packages of it and its dependencies
+type Request struct {
+	Package    string
+	Recommends bool
 }
 
 func (pm *PackageManager) addRepositoryWithExtends(index, list string, repos []*Repository, resultErr *multierror.Error) ([]*Repository, *multierror.Error) {
@@ -19,6 +33,7 @@ func (pm *PackageManager) addRepositoryWithExtends(index, list string, repos []*
 		return []*Repository{}, multierror.Append(resultErr, err)
 	}
 	repos = append(repos, r)
+	pm.events.Emit(events.Event{Type: events.ListFetched, List: list})
 
 	if len(r.Info.Depends) == 0 {
 		// Just add the repo and return
@@ -54,6 +69,13 @@ func (pm *PackageManager) AddRepository(index, url string) error {
 	resultErr := &multierror.Error{}
 	repos, resultErr = pm.addRepositoryWithExtends(index, url, repos, resultErr)
 
+	// Every repo except the requested one got pulled in by "depends"
+	if len(repos) > 1 {
+		for _, r := range repos[1:] {
+			r.SetDependency(true)
+		}
+	}
+
 	// Reverse the list of repos
 	// See: https://stackoverflow.com/a/19239850
 	for i, j := 0, len(repos)-1; i < j; i, j = i+1, j-1 {
@@ -66,6 +88,11 @@ func (pm *PackageManager) AddRepository(index, url string) error {
 	return resultErr.ErrorOrNil()
 }
 
+// SetEvents sets the sink for the list-fetched and package-resolved events
+func (pm *PackageManager) SetEvents(sink events.Sink) {
+	pm.events = sink
+}
+
 func (rh *PackageManager) GetRepositories() []*Repository {
 	return rh.repos
 }
@@ -94,7 +121,7 @@ func (pm *PackageManager) Validate() error {
 			// }
 			pm.packages[p.Name] = &p
 			if len(p.Provides) > 0 {
-				for _, pn := range p.Provides {
+				for _, pn := range p.ProvidedNames() {
 					if _, ok := pm.providers[pn]; !ok {
 						pm.providers[pn] = make(map[string]*RPackage)
 					}
@@ -104,13 +131,35 @@ func (pm *PackageManager) Validate() error {
 		}
 	}
 
+	// Check types, plugins must extend a known app
+	for _, p := range pm.packages {
+		if err := ValidateType(p.Name, p.Type, p.Extends); err != nil {
+			rErr = multierror.Append(rErr, fmt.Errorf("%v: %v", p.Repository.GetList(), err))
+			continue
+		}
+		if p.Extends == "" {
+			continue
+		}
+		if app, ok := pm.packages[p.Extends]; !ok {
+			rErr = multierror.Append(rErr, fmt.Errorf("%v: Plugin \"%s\" extends the unknown app \"%s\"", p.Repository.GetList(), p.Name, p.Extends))
+		} else if app.Kind() != TypeApp {
+			rErr = multierror.Append(rErr, fmt.Errorf("%v: Plugin \"%s\" extends \"%s\" which is a %s and not an %s", p.Repository.GetList(), p.Name, p.Extends, app.Kind(), TypeApp))
+		}
+	}
+
 	// Check Dependencies
 	for _, p := range pm.packages {
 		for _, d := range p.Dependencies {
+			if _, err := common.MatchVersion(d.Version, ""); err != nil {
+				rErr = multierror.Append(rErr, fmt.Errorf("%v: %v for package \"%s\"", p.Repository.GetList(), err, p.Name))
+			}
+
 			if prov, ok := pm.providers[d.Package]; ok {
 				if d.Default != "" {
-					if _, ok := prov[d.Default]; !ok {
+					if dp, ok := prov[d.Default]; !ok {
 						rErr = multierror.Append(rErr, fmt.Errorf("%v: Unknown default dependency package \"%s\" for package \"%s\"", p.Repository.GetList(), d.Default, p.Name))
+					} else if !dp.Satisfies(d.Package, d.Version) {
+						rErr = multierror.Append(rErr, fmt.Errorf("%v: Default \"%s\" of package \"%s\" doesn't provide \"%s\" %s", p.Repository.GetList(), d.Default, p.Name, d.Package, d.Version))
 					}
 				}
 				continue
@@ -131,7 +180,7 @@ func (pm *PackageManager) Validate() error {
 				// depends self
 				rErr = multierror.Append(rErr, fmt.Errorf("%v: Package \"%s\" depends on itself", p.Repository.GetList(), p.Name))
 			}
-			for _, pn := range p.Provides {
+			for _, pn := range p.ProvidedNames() {
 				if d.Package == pn || d.Default == pn {
 					// provides self
 					rErr = multierror.Append(rErr, fmt.Errorf("%v: Package \"%s\" depends on \"%s\" and provides \"%s\"", p.Repository.GetList(), p.Name, pn, pn))
@@ -155,38 +204,74 @@ func (pm *PackageManager) getDependencies(inPackages []*RPackage, kpn map[string
 			for _, d := range p.Recommends {
 				all = append(all, d)
 			}
+		} else {
+			for _, d := range p.Recommends {
+				pm.record(Decision{Kind: DecisionSkipped, Package: d.Package, By: p.Name, Recommends: true})
+			}
 		}
 
-		for _, d := range all {
+		for i, d := range all {
+			isRecommends := i >= len(p.Dependencies)
+
 			// Check if already known
 			if _, ok := kpn[d.Package]; ok {
 				// Package or Provider already known
+				known := pm.knownBy(d.Package)
+				if kp, ok := pm.packages[known]; ok && !kp.Satisfies(d.Package, d.Version) {
+					version, _ := kp.ProvidedVersion(d.Package)
+					pm.record(Decision{Kind: DecisionMismatch, Package: d.Package, By: p.Name, Dependency: d.Package, Recommends: isRecommends, Provider: known, Constraint: d.Version})
+					rErr = multierror.Append(rErr, fmt.Errorf("Package \"%s\" needs \"%s\" %s but \"%s\" provides version \"%s\"", p.Name, d.Package, d.Version, known, version))
+					continue
+				}
+				pm.record(Decision{Kind: DecisionSatisfied, Package: d.Package, By: p.Name, Dependency: d.Package, Recommends: isRecommends, Provider: known})
 				continue
 			}
 
 			// Check if its a provider package
 			if _, ok := pm.providers[d.Package]; ok {
-				// Check if has default
-				if d.Default != "" {
-					// Default already known
-					if _, ok := kpn[d.Default]; ok {
+				provider, kind, preference := d.Default, DecisionDefault, ""
+				if _, real := pm.packages[d.Package]; provider == "" && !real {
+					// No default, use the chosen or the only provider
+					chosen, pref, candidates, err := pm.chooseProvider(d.Package, d.Version)
+					if err != nil {
+						rErr = multierror.Append(rErr, err)
+						continue
+					}
+					if chosen == "" {
+						pm.record(Decision{Kind: DecisionAmbiguous, Package: d.Package, By: p.Name, Dependency: d.Package, Recommends: isRecommends})
+						rErr = multierror.Append(rErr, &ProviderChoiceError{Package: d.Package, By: p.Name, Candidates: candidates})
+						continue
+					}
+					provider, kind, preference = chosen, DecisionProvider, pref
+				}
+
+				// Check if has a default or chosen provider
+				if provider != "" {
+					// Provider already known
+					if _, ok := kpn[provider]; ok {
+						pm.record(Decision{Kind: DecisionSatisfied, Package: provider, By: p.Name, Dependency: d.Package, Recommends: isRecommends, Provider: pm.knownBy(provider)})
 						continue
 					}
 
-					// Check if default is a known package
-					dp, ok := pm.packages[d.Default]
+					// Check if the provider is a known package
+					dp, ok := pm.packages[provider]
 					if !ok {
-						rErr = multierror.Append(rErr, fmt.Errorf("Unknown default package \"%s\"", d.Default))
+						pm.record(Decision{Kind: DecisionUnknown, Package: provider, By: p.Name, Dependency: d.Package, Recommends: isRecommends})
+						rErr = multierror.Append(rErr, fmt.Errorf("Unknown default package \"%s\"", provider))
+						continue
+					}
+					if pm.excluded(dp.Name, p.Name, d.Package, isRecommends, &rErr) {
 						continue
 					}
 
-					// We know the default, check if it is known
+					// We know the provider, check if it is known
 					if _, ok := kpn[dp.Name]; !ok {
 						// And its not already known
 						myPkgs = append(myPkgs, dp)
+						pm.record(Decision{Kind: kind, Package: dp.Name, By: p.Name, Dependency: d.Package, Recommends: isRecommends, Preference: preference})
 					}
 					kpn[dp.Name] = 0
-					for _, prov := range dp.Provides {
+					for _, prov := range dp.ProvidedNames() {
 						kpn[prov] = 0
 					}
 
@@ -197,15 +282,26 @@ func (pm *PackageManager) getDependencies(inPackages []*RPackage, kpn map[string
 			// Check if a known package
 			dp, ok := pm.packages[d.Package]
 			if !ok {
+				pm.record(Decision{Kind: DecisionUnknown, Package: d.Package, By: p.Name, Recommends: isRecommends})
 				rErr = multierror.Append(rErr, fmt.Errorf("Unknown package \"%s\"", d.Package))
 				continue
 			}
+			if pm.excluded(dp.Name, p.Name, d.Package, isRecommends, &rErr) {
+				continue
+			}
+			if !dp.Satisfies(d.Package, d.Version) {
+				pm.record(Decision{Kind: DecisionMismatch, Package: dp.Name, By: p.Name, Dependency: d.Package, Recommends: isRecommends, Constraint: d.Version})
+				rErr = multierror.Append(rErr, fmt.Errorf("Package \"%s\" needs \"%s\" %s but version \"%s\" is available", p.Name, d.Package, d.Version, dp.Version))
+				continue
+			}
+
 			// We know the package add it
 			if _, ok := kpn[dp.Name]; !ok {
 				myPkgs = append(myPkgs, dp)
+				pm.record(Decision{Kind: DecisionDependency, Package: dp.Name, By: p.Name, Dependency: d.Package, Recommends: isRecommends})
 			}
 			kpn[dp.Name] = 0
-			for _, prov := range dp.Provides {
+			for _, prov := range dp.ProvidedNames() {
 				kpn[prov] = 0
 			}
 		}
@@ -220,26 +316,67 @@ func (pm *PackageManager) getDependencies(inPackages []*RPackage, kpn map[string
 	return inPackages, kpn, rErr
 }
 
+// excluded reports if package name is in "without", by depends on or recommends it
+// through dependency, excluding a dependency is an error
+func (pm *PackageManager) excluded(name, by, dependency string, recommends bool, rErr **multierror.Error) bool {
+	if !pm.without[name] {
+		return false
+	}
+
+	pm.record(Decision{Kind: DecisionExcluded, Package: name, By: by, Dependency: dependency, Recommends: recommends})
+	if !recommends {
+		*rErr = multierror.Append(*rErr, fmt.Errorf("Package \"%s\" depends on \"%s\" which is excluded by \"without\"", by, name))
+	}
+
+	return true
+}
+
 func (pm *PackageManager) GetDependencies(from []string, recommends bool) ([]*RPackage, *multierror.Error) {
+	requests := make([]Request, len(from))
+	for i, name := range from {
+		requests[i] = Request{Package: name, Recommends: recommends}
+	}
+
+	return pm.GetDependenciesFor(requests, nil)
+}
+
+// GetDependenciesFor resolves the requested packages with recommends enabled
+// per request, packages in without never get pulled in
+func (pm *PackageManager) GetDependenciesFor(requests []Request, without []string) ([]*RPackage, *multierror.Error) {
 	resultPackages := []*RPackage{}
+	withoutRecommends := []*RPackage{}
 	names := make(map[string]int)
 	resultErr := &multierror.Error{}
+	pm.trace = []Decision{}
+	pm.without = make(map[string]bool)
+	for _, w := range without {
+		pm.without[w] = true
+	}
 
-	for _, myDep := range from {
+	for _, req := range requests {
+		myDep := req.Package
 		p, ok := pm.packages[myDep]
 		if !ok {
+			pm.record(Decision{Kind: DecisionUnknown, Package: myDep})
 			resultErr = multierror.Append(resultErr, fmt.Errorf("Unknown package \"%s\"", myDep))
 			continue
 		}
 
+		if pm.without[p.Name] {
+			pm.record(Decision{Kind: DecisionExcluded, Package: p.Name})
+			resultErr = multierror.Append(resultErr, fmt.Errorf("Package \"%s\" is installed explicitly but excluded by \"without\"", p.Name))
+			continue
+		}
+
 		if _, ok := names[p.Name]; ok {
+			pm.record(Decision{Kind: DecisionDuplicate, Package: p.Name})
 			resultErr = multierror.Append(resultErr, warning.Wrap(fmt.Errorf("Theres a duplicated reference to package \"%s\"", p.Name)))
 			continue
 		}
 		names[p.Name] = 0
 
 		known := []string{}
-		for _, prov := range p.Provides {
+		for _, prov := range p.ProvidedNames() {
 			if _, ok := names[prov]; ok {
 				known = append(known, prov)
 				continue
@@ -247,14 +384,33 @@ func (pm *PackageManager) GetDependencies(from []string, recommends bool) ([]*RP
 			names[prov] = 0
 		}
 		if len(known) > 0 {
+			for _, k := range known {
+				pm.record(Decision{Kind: DecisionConflict, Package: p.Name, Dependency: k, Provider: pm.knownBy(k)})
+			}
 			resultErr = multierror.Append(resultErr, warning.Wrap(fmt.Errorf("Provider/s %v of package %v is/are already known", known, p.Name)))
 			continue
 		}
 
-		resultPackages = append(resultPackages, p)
+		if req.Recommends {
+			resultPackages = append(resultPackages, p)
+		} else {
+			withoutRecommends = append(withoutRecommends, p)
+		}
+		pm.record(Decision{Kind: DecisionExplicit, Package: p.Name})
 	}
 
-	resultPackages, names, resultErr = pm.getDependencies(resultPackages, names, resultErr, recommends)
+	// Packages with recommends first, a package both need gets its recommends
+	if len(resultPackages) > 0 {
+		resultPackages, names, resultErr = pm.getDependencies(resultPackages, names, resultErr, true)
+	}
+	if len(withoutRecommends) > 0 {
+		withoutRecommends, names, resultErr = pm.getDependencies(withoutRecommends, names, resultErr, false)
+		resultPackages = append(resultPackages, withoutRecommends...)
+	}
+
+	for _, p := range resultPackages {
+		pm.events.Emit(events.Event{Type: events.PackageResolved, Package: p.Name, Version: p.Version, List: p.Repository.GetList()})
+	}
 
 	return resultPackages, resultErr
 }
@@ -2,10 +2,15 @@ package pm
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/tpazderka/warning"
+	"github.com/zemm-io/zemm/common"
 )
 
 func filterPrintWarning(err error) error {
@@ -162,3 +167,341 @@ func TestDependenciesOfMinadmin(t *testing.T) {
 	}
 
 }
+
+func TestWhyAndWhyNot(t *testing.T) {
+	pm, err := NewPackageManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.AddRepository("../examples/repo/", "minadmin/minadmin/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	pm.GetDependencies([]string{"minadmin/minadmin_pgsql"}, true)
+
+	why, err := pm.Why("library/postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(why) < 2 || why[0].Kind != DecisionExplicit || why[0].Package != "minadmin/minadmin_pgsql" || why[len(why)-1].Package != "library/postgres" {
+		t.Error(fmt.Errorf("Invalid path to library/postgres: %v", why))
+	}
+	for i := 1; i < len(why); i++ {
+		if why[i].By != why[i-1].Package {
+			t.Error(fmt.Errorf("Path to library/postgres is broken: %v", why))
+		}
+	}
+
+	whyNot, err := pm.WhyNot("tuatzemm/settings_pgsql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(whyNot) == 0 || whyNot[0].Kind != DecisionSatisfied || whyNot[0].Provider != "minadmin/minadmin_pgsql" {
+		t.Error(fmt.Errorf("tuatzemm/settings should be provided by minadmin/minadmin_pgsql: %v", whyNot))
+	}
+
+	if _, err := pm.WhyNot("library/nats"); err == nil {
+		t.Error(fmt.Errorf("library/nats has been selected"))
+	}
+	if whyNot, _ := pm.WhyNot("library/unknown"); len(whyNot) != 1 || whyNot[0].Kind != DecisionUnknown {
+		t.Error(fmt.Errorf("library/unknown should be unknown: %v", whyNot))
+	}
+}
+
+func TestResolvedGraph(t *testing.T) {
+	pm, err := NewPackageManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.AddRepository("../examples/repo/", "minadmin/minadmin/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	pkgs, _ := pm.GetDependencies([]string{"minadmin/minadmin_pgsql"}, true)
+
+	g := pm.ResolvedGraph()
+	nodes := make(map[string]GraphNode)
+	for _, n := range g.Nodes {
+		nodes[n.Name] = n
+	}
+	for _, p := range pkgs {
+		n, ok := nodes[p.Name]
+		if !ok || n.Version != p.Version || n.List != p.Repository.GetList() || n.Virtual {
+			t.Error(fmt.Errorf("Invalid node for %s: %v", p.Name, n))
+		}
+	}
+	if !nodes["minadmin/minadmin_pgsql"].Explicit || !nodes["tuatzemm/settings"].Virtual {
+		t.Error(fmt.Errorf("Invalid nodes: %v", g.Nodes))
+	}
+
+	kinds := make(map[string]bool)
+	for _, e := range g.Edges {
+		if _, ok := nodes[e.From]; !ok {
+			t.Error(fmt.Errorf("Edge from unknown node: %v", e))
+		}
+		if _, ok := nodes[e.To]; !ok {
+			t.Error(fmt.Errorf("Edge to unknown node: %v", e))
+		}
+		kinds[e.Kind] = true
+	}
+	if !kinds[EdgeDepends] || !kinds[EdgeProvides] {
+		t.Error(fmt.Errorf("Missing edge kinds: %v", g.Edges))
+	}
+
+	c := g.Collapse()
+	for _, n := range c.Nodes {
+		if strings.Contains(n.Name, "/") {
+			t.Error(fmt.Errorf("Node %s has not been collapsed", n.Name))
+		}
+	}
+	for _, e := range c.Edges {
+		if e.From == e.To {
+			t.Error(fmt.Errorf("Collapsed graph has a self loop: %v", e))
+		}
+	}
+
+	if dot := g.DOT(); !strings.Contains(dot, "\"minadmin/minadmin_pgsql\" -> ") {
+		t.Error(fmt.Errorf("Invalid DOT output: %s", dot))
+	}
+	if mermaid := g.Mermaid(); !strings.HasPrefix(mermaid, "graph LR\n") {
+		t.Error(fmt.Errorf("Invalid Mermaid output: %s", mermaid))
+	}
+}
+
+func TestRecommendsAndWithout(t *testing.T) {
+	pm, err := NewPackageManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.AddRepository("../examples/repo/", "minadmin/minadmin/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(pkgs []*RPackage) []string {
+		result := []string{}
+		for _, p := range pkgs {
+			result = append(result, p.Name)
+		}
+		return result
+	}
+
+	pkgs, err := pm.GetDependenciesFor([]Request{{Package: "minadmin/minadmin_pgsql", Recommends: true}}, nil)
+	if err := filterPrintWarning(err); err != nil {
+		t.Fatal(err)
+	}
+	if !stringSliceContains(names(pkgs), "library/postgres") {
+		t.Error(fmt.Errorf("library/postgres should be recommended: %v", names(pkgs)))
+	}
+	if only := pm.RecommendsOnly(); len(only) != 1 || only[0] != "library/postgres" {
+		t.Error(fmt.Errorf("Only library/postgres should be installed because of recommends, got %v", only))
+	}
+
+	pkgs, err = pm.GetDependenciesFor([]Request{{Package: "minadmin/minadmin_pgsql", Recommends: false}}, nil)
+	if err := filterPrintWarning(err); err != nil {
+		t.Fatal(err)
+	}
+	if stringSliceContains(names(pkgs), "library/postgres") || len(pm.RecommendsOnly()) != 0 {
+		t.Error(fmt.Errorf("Recommends are disabled for minadmin/minadmin_pgsql: %v", names(pkgs)))
+	}
+
+	pkgs, err = pm.GetDependenciesFor([]Request{{Package: "minadmin/minadmin_pgsql", Recommends: true}}, []string{"library/postgres"})
+	if err := filterPrintWarning(err); err != nil {
+		t.Fatal(err)
+	}
+	if stringSliceContains(names(pkgs), "library/postgres") {
+		t.Error(fmt.Errorf("library/postgres is excluded: %v", names(pkgs)))
+	}
+	if whyNot, _ := pm.WhyNot("library/postgres"); len(whyNot) == 0 || whyNot[0].Kind != DecisionExcluded {
+		t.Error(fmt.Errorf("library/postgres should be excluded: %v", whyNot))
+	}
+
+	_, err = pm.GetDependenciesFor([]Request{{Package: "minadmin/minadmin_pgsql", Recommends: true}}, []string{"tuatzemm/sql_pgsql"})
+	if err := filterPrintWarning(err); err == nil {
+		t.Error(fmt.Errorf("Excluding a dependency should fail"))
+	}
+
+	_, err = pm.GetDependenciesFor([]Request{{Package: "minadmin/minadmin_mysql"}}, []string{"tuatzemm/abac_mysql"})
+	if err := filterPrintWarning(err); err == nil {
+		t.Error(fmt.Errorf("Excluding a default provider should fail"))
+	}
+}
+
+func TestProviderChoice(t *testing.T) {
+	pm, err := NewPackageManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.AddRepository("../examples/repo/", "minadmin/minadmin/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, rErr := pm.GetDependencies([]string{"tuatzemm/auth"}, false)
+	choices := ProviderChoices(rErr)
+	if len(choices) != 1 || choices[0].Package != "tuatzemm/settings" || choices[0].By != "tuatzemm/auth" {
+		t.Fatal(fmt.Errorf("tuatzemm/settings should need a choice: %v", rErr))
+	}
+	expected := []string{"minadmin/minadmin_pgsql", "tuatzemm/settings_mysql", "tuatzemm/settings_pgsql"}
+	if fmt.Sprint(choices[0].Candidates) != fmt.Sprint(expected) {
+		t.Error(fmt.Errorf("Expected the candidates %v, got %v", expected, choices[0].Candidates))
+	}
+
+	pm.SetProviders(map[string]string{"tuatzemm/settings": "tuatzemm/settings_mysql"})
+	pkgs, rErr := pm.GetDependencies([]string{"tuatzemm/auth"}, false)
+	if err := filterPrintWarning(rErr); err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 || pkgs[1].Name != "tuatzemm/settings_mysql" {
+		t.Error(fmt.Errorf("The chosen provider tuatzemm/settings_mysql should be selected: %v", pkgs))
+	}
+
+	pm.SetProviders(map[string]string{"tuatzemm/settings": "library/nats"})
+	if _, rErr := pm.GetDependencies([]string{"tuatzemm/auth"}, false); rErr.ErrorOrNil() == nil {
+		t.Error(fmt.Errorf("Choosing a package that doesn't provide tuatzemm/settings should fail"))
+	}
+}
+
+func TestPreferredProviders(t *testing.T) {
+	pm, err := NewPackageManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.AddRepository("../examples/repo/", "minadmin/minadmin/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefer := range [][]string{{"*_mysql"}, {"library/*", "tuatzemm/settings_mysql", "*_pgsql"}} {
+		if err := pm.SetPrefer(prefer); err != nil {
+			t.Fatal(err)
+		}
+		pkgs, rErr := pm.GetDependencies([]string{"tuatzemm/auth"}, false)
+		if err := filterPrintWarning(rErr); err != nil {
+			t.Fatal(err)
+		}
+		if len(pkgs) != 2 || pkgs[1].Name != "tuatzemm/settings_mysql" {
+			t.Error(fmt.Errorf("Preferences %v should select tuatzemm/settings_mysql: %v", prefer, pkgs))
+		}
+		if preferred := pm.Preferred(); len(preferred) != 1 || preferred[0].Dependency != "tuatzemm/settings" {
+			t.Error(fmt.Errorf("Preferences %v are not reported: %v", prefer, preferred))
+		}
+	}
+
+	// Matches minadmin/minadmin_pgsql and tuatzemm/settings_pgsql
+	if err := pm.SetPrefer([]string{"*_pgsql"}); err != nil {
+		t.Fatal(err)
+	}
+	_, rErr := pm.GetDependencies([]string{"tuatzemm/auth"}, false)
+	if choices := ProviderChoices(rErr); len(choices) != 1 || len(choices[0].Candidates) != 2 {
+		t.Error(fmt.Errorf("Two preferred providers should need a choice: %v", rErr))
+	}
+
+	if err := pm.SetPrefer([]string{"["}); err == nil {
+		t.Error(fmt.Errorf("Invalid patterns should fail"))
+	}
+}
+
+func TestVersionedProvides(t *testing.T) {
+	index, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(index)
+
+	list := `info:
+  name: test
+  version: 1.0.0
+packages:
+  - name: test/settings_v1
+    version: "1.0.0"
+    provides:
+      - test/settings=1.5
+  - name: test/settings_v2
+    version: "1.0.0"
+    provides:
+      - test/settings=2.1
+  - name: test/settings_legacy
+    version: "1.0.0"
+    provides:
+      - test/settings
+  - name: test/app
+    version: "1.0.0"
+    dependencies:
+      - package: test/settings
+        version: ">=2"
+  - name: test/old
+    version: "1.0.0"
+    dependencies:
+      - package: test/settings
+        version: "<2"
+        default: test/settings_v1
+  - name: test/lib
+    version: "1.2.0"
+  - name: test/needs_lib
+    version: "1.0.0"
+    dependencies:
+      - package: test/lib
+        version: ">=2"
+`
+	if err := os.MkdirAll(path.Join(index, "lists", "test", "suite"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(index, "lists", "test", "suite", "1.0.0.yaml"), []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pm, err := NewPackageManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.AddRepository(index, "test/suite/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs, rErr := pm.GetDependencies([]string{"test/app"}, false)
+	if err := filterPrintWarning(rErr); err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 || pkgs[1].Name != "test/settings_v2" {
+		t.Error(fmt.Errorf("test/settings >=2 should select test/settings_v2: %v", pkgs))
+	}
+
+	pkgs, rErr = pm.GetDependencies([]string{"test/old"}, false)
+	if err := filterPrintWarning(rErr); err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 || pkgs[1].Name != "test/settings_v1" {
+		t.Error(fmt.Errorf("test/settings <2 should select test/settings_v1: %v", pkgs))
+	}
+
+	// test/settings_v2 provides test/settings already
+	if _, rErr := pm.GetDependencies([]string{"test/app", "test/old"}, false); filterPrintWarning(rErr) == nil {
+		t.Error(fmt.Errorf("test/old must not accept test/settings 2.1"))
+	}
+	if _, rErr := pm.GetDependencies([]string{"test/needs_lib"}, false); filterPrintWarning(rErr) == nil {
+		t.Error(fmt.Errorf("test/lib 1.2.0 doesn't match >=2"))
+	}
+
+	for constraint, expected := range map[string]bool{">=2": true, ">=2,<2.1": false, "2.1": true, "!=2.1": false, "<3": true} {
+		if ok, err := common.MatchVersion(constraint, "2.1"); err != nil || ok != expected {
+			t.Error(fmt.Errorf("Version 2.1 should match \"%s\": %v, got %v (%v)", constraint, expected, ok, err))
+		}
+	}
+	if _, err := common.MatchVersion(">=", "2.1"); err == nil {
+		t.Error(fmt.Errorf("Invalid constraints should fail"))
+	}
+}
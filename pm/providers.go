@@ -0,0 +1,134 @@
+package pm
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// ProviderChoiceError reports a dependency on a virtual package with several
+// providers and no default, one of Candidates has to be chosen
+type ProviderChoiceError struct {
+	Package    string
+	By         string
+	Candidates []string
+}
+
+func (e *ProviderChoiceError) Error() string {
+	return fmt.Sprintf("Package \"%s\" depends on \"%s\" which has several providers, choose one of: %s", e.By, e.Package, strings.Join(e.Candidates, ", "))
+}
+
+// ProviderChoices returns the provider choices in err
+func ProviderChoices(err error) []*ProviderChoiceError {
+	result := []*ProviderChoiceError{}
+
+	switch v := err.(type) {
+	case *ProviderChoiceError:
+		result = append(result, v)
+	case *multierror.Error:
+		for _, merr := range v.WrappedErrors() {
+			result = append(result, ProviderChoices(merr)...)
+		}
+	}
+
+	return result
+}
+
+// SetProviders sets the chosen providers, virtual package => provider, they
+// are used for dependencies without a default
+func (pm *PackageManager) SetProviders(providers map[string]string) {
+	pm.chosen = providers
+}
+
+// SetPrefer sets the preferred providers, exact names or patterns like
+// "*_pgsql", patterns without "/" match the name without namespace
+func (pm *PackageManager) SetPrefer(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("Invalid preference \"%s\": %v", p, err)
+		}
+	}
+
+	pm.prefer = patterns
+	return nil
+}
+
+// preferred returns the index of the first preference matching package
+// name, -1 if none matches
+func (pm *PackageManager) preferred(name string) int {
+	for i, p := range pm.prefer {
+		subject := name
+		if !strings.Contains(p, "/") {
+			subject = name[strings.LastIndex(name, "/")+1:]
+		}
+		if ok, _ := path.Match(p, subject); ok {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Providers returns the names of the packages providing the virtual package name
+func (pm *PackageManager) Providers(name string) []string {
+	result := []string{}
+	for p := range pm.providers[name] {
+		result = append(result, p)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// chooseProvider returns the chosen, the preferred or the only provider of
+// the virtual package name matching constraint and the preference that
+// selected it, the candidates left if there is no choice
+func (pm *PackageManager) chooseProvider(name, constraint string) (string, string, []string, error) {
+	if chosen, ok := pm.chosen[name]; ok {
+		cp, ok := pm.providers[name][chosen]
+		if !ok {
+			return "", "", nil, fmt.Errorf("Package \"%s\" has been chosen as provider of \"%s\" but doesn't provide it", chosen, name)
+		}
+		if !cp.Satisfies(name, constraint) {
+			return "", "", nil, fmt.Errorf("Package \"%s\" has been chosen as provider of \"%s\" but doesn't provide %s", chosen, name, constraint)
+		}
+		return chosen, "", nil, nil
+	}
+
+	candidates := []string{}
+	for _, c := range pm.Providers(name) {
+		if pm.providers[name][c].Satisfies(name, constraint) {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", "", nil, fmt.Errorf("No package provides \"%s\" %s", name, constraint)
+	}
+	if len(candidates) == 1 {
+		return candidates[0], "", nil, nil
+	}
+
+	// The providers matching the earliest preference
+	best, preferred := -1, []string{}
+	for _, c := range candidates {
+		rank := pm.preferred(c)
+		switch {
+		case rank == -1:
+		case best == -1 || rank < best:
+			best, preferred = rank, []string{c}
+		case rank == best:
+			preferred = append(preferred, c)
+		}
+	}
+	if len(preferred) == 1 {
+		return preferred[0], pm.prefer[best], nil, nil
+	}
+	if len(preferred) > 1 {
+		return "", "", preferred, nil
+	}
+
+	return "", "", candidates, nil
+}
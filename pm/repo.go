@@ -2,8 +2,11 @@ package pm
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/url"
 	"path"
+	"sort"
+	"strings"
 
 	"encoding/json"
 
@@ -24,16 +27,54 @@ type RInfo struct {
 	Supports    []ListOrPackage `json:"supports,omitempty" yaml:"supports,omitempty"`
 }
 
+const (
+	// TypeApp is an application, the only type that can be an overlay
+	TypeApp = "app"
+	// TypeService is a service apps use, the default
+	TypeService = "service"
+	// TypeLibrary is shared code or configuration without services
+	TypeLibrary = "library"
+	// TypePlugin extends an app
+	TypePlugin = "plugin"
+)
+
+// PackageTypes are the valid package types
+var PackageTypes = []string{TypeApp, TypeService, TypeLibrary, TypePlugin}
+
+// ValidateType checks the type of package name, a plugin must declare the app it extends
+func ValidateType(name, typ, extends string) error {
+	switch typ {
+	case "", TypeApp, TypeService, TypeLibrary:
+		if extends != "" {
+			return fmt.Errorf("Package \"%s\" extends \"%s\" but only a %s can extend an app", name, extends, TypePlugin)
+		}
+	case TypePlugin:
+		if extends == "" {
+			return fmt.Errorf("Plugin \"%s\" must declare the app it extends in \"extends\"", name)
+		}
+	default:
+		return fmt.Errorf("Unknown type \"%s\" of package \"%s\", use one of %s", typ, name, strings.Join(PackageTypes, ", "))
+	}
+
+	return nil
+}
+
 type RPDependency struct {
 	Package string `json:"package" yaml:"package"`
+	// Version constrains the version of Package or the version a provider provides it in, e.g. ">=2"
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
 	Default string `json:"default,omitempty" yaml:"default,omitempty"`
 }
 
-type RPackage struct {
-	Repository   *Repository    `json:"-" yaml:"-"`
-	Name         string         `json:"name" yaml:"name"`
-	Deprecation  string         `json:"deprecation" yaml:"deprecation"`
-	Description  string         `json:"description" yaml:"description"`
+// PackageInfo is the metadata of a package, the same in a list entry and
+// in the zemmpkg.yaml of the package
+type PackageInfo struct {
+	Name        string `json:"name" yaml:"name"`
+	Version     string `json:"version" yaml:"version"`
+	Description string `json:"description" yaml:"description"`
+	Type        string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Extends is the app a plugin extends
+	Extends      string         `json:"extends,omitempty" yaml:"extends,omitempty"`
 	Author       string         `json:"author" yaml:"author"`
 	Packager     string         `json:"packager,omitempty" yaml:"packager,omitempty"`
 	License      string         `json:"license" yaml:"license"`
@@ -44,6 +85,69 @@ type RPackage struct {
 	Recommends   []RPDependency `json:"recommends" yaml:"recommends"`
 }
 
+// RPackage is a package entry of a list
+type RPackage struct {
+	Repository  *Repository `json:"-" yaml:"-"`
+	PackageInfo `yaml:",inline"`
+	Deprecation string `json:"deprecation" yaml:"deprecation"`
+}
+
+// Kind returns the type of p, TypeService if it has none
+func (p *PackageInfo) Kind() string {
+	if p.Type == "" {
+		return TypeService
+	}
+
+	return p.Type
+}
+
+// splitProvides splits a provides entry like "tuatzemm/settings=2.1" into
+// name and version, the version is empty for unversioned entries
+func splitProvides(entry string) (string, string) {
+	exp := strings.SplitN(entry, "=", 2)
+	if len(exp) == 1 {
+		return strings.TrimSpace(exp[0]), ""
+	}
+
+	return strings.TrimSpace(exp[0]), strings.TrimSpace(exp[1])
+}
+
+// ProvidedNames returns the names of the virtual packages p provides
+func (p *PackageInfo) ProvidedNames() []string {
+	result := []string{}
+	for _, entry := range p.Provides {
+		name, _ := splitProvides(entry)
+		result = append(result, name)
+	}
+
+	return result
+}
+
+// ProvidedVersion returns the version p provides name in, its own version
+// for its name, the version is empty for unversioned provides
+func (p *PackageInfo) ProvidedVersion(name string) (string, bool) {
+	if name == p.Name {
+		return p.Version, true
+	}
+	for _, entry := range p.Provides {
+		if n, v := splitProvides(entry); n == name {
+			return v, true
+		}
+	}
+
+	return "", false
+}
+
+// Satisfies reports if p is or provides name in a version matching constraint
+func (p *PackageInfo) Satisfies(name, constraint string) bool {
+	version, ok := p.ProvidedVersion(name)
+	if !ok {
+		return false
+	}
+	match, err := common.MatchVersion(constraint, version)
+	return err == nil && match
+}
+
 type Repository struct {
 	index        string     `json:"-" yaml:"-"`
 	list         string     `json:"-" yaml:"-"`
@@ -122,3 +226,108 @@ func (r *Repository) SetDependency(isDep bool) {
 func (r *Repository) DumpJSON() ([]byte, error) {
 	return json.Marshal(r)
 }
+
+// SplitList splits a list reference like "tuatzemm/suite/1.0.1" into
+// its name "tuatzemm/suite" and version "1.0.1"
+func SplitList(list string) (string, string) {
+	return path.Dir(list), path.Base(list)
+}
+
+// ListVersions returns all versions of the list name (without version)
+// available on index, sorted from old to new.
+//
+// Local indexes get scanned, HTTP indexes must serve a JSON array
+// of versions at "lists/<name>/index.json".
+func ListVersions(index, name string) ([]string, error) {
+	versions := []string{}
+
+	if common.URLIsValidAndHTTP(index) {
+		u := common.URLAndPathJoin(index, path.Join("lists", name, "index.json"))
+		if err := common.URLToStruct(u, &versions); err != nil {
+			return nil, err
+		}
+	} else {
+		d := path.Join(index, "lists", name)
+		entries, err := ioutil.ReadDir(d)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read versions of list %s, error was: %s", name, err)
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			n := e.Name()
+			if ext := path.Ext(n); ext == ".yaml" || ext == ".json" {
+				n = strings.TrimSuffix(n, ext)
+			}
+			versions = append(versions, n)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return common.CompareVersions(versions[i], versions[j]) < 0
+	})
+
+	return versions, nil
+}
+
+// VersionsCompatible reports if version b can replace version a, which means
+// they share the same major version (or the same minor version for 0.x)
+func VersionsCompatible(a, b string) bool {
+	ap := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bp := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	if ap[0] != bp[0] {
+		return false
+	}
+	if ap[0] == "0" {
+		return len(ap) > 1 && len(bp) > 1 && ap[1] == bp[1]
+	}
+
+	return true
+}
+
+// NewestCompatibleList returns the newest compatible version of list on index,
+// if there is none it returns list itself
+func NewestCompatibleList(index, list string) (string, error) {
+	name, current := SplitList(list)
+
+	versions, err := ListVersions(index, name)
+	if err != nil {
+		return "", err
+	}
+
+	newest := current
+	for _, v := range versions {
+		if VersionsCompatible(current, v) && common.CompareVersions(v, newest) > 0 {
+			newest = v
+		}
+	}
+
+	return path.Join(name, newest), nil
+}
+
+// SupportsPackage reports if the list supports package name, either by naming
+// the package or one of the lists of its "supports" containing it
+func (r *Repository) SupportsPackage(name string) (bool, error) {
+	for _, s := range r.Info.Supports {
+		if s.Package != "" && strings.SplitN(s.Package, "@", 2)[0] == name {
+			return true, nil
+		}
+		if s.List == "" {
+			continue
+		}
+
+		supported, err := NewRepository(r.index, s.List)
+		if err != nil {
+			return false, err
+		}
+		for _, p := range supported.Packages {
+			if p.Name == name {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
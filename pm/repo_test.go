@@ -1,6 +1,7 @@
 package pm
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -10,3 +11,36 @@ func TestReadRepository(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestNewestCompatibleList(t *testing.T) {
+	l, err := NewestCompatibleList("../examples/repo/", "tuatzemm/suite/1.0.0")
+	if err != nil {
+		t.Error(err)
+	}
+	if l != "tuatzemm/suite/1.0.1" {
+		t.Error(fmt.Errorf("Invalid newest list: %s", l))
+	}
+
+	if VersionsCompatible("1.0.0", "2.0.0") {
+		t.Error(fmt.Errorf("1.0.0 and 2.0.0 shouldn't be compatible"))
+	}
+	if VersionsCompatible("0.1.0", "0.2.0") {
+		t.Error(fmt.Errorf("0.1.0 and 0.2.0 shouldn't be compatible"))
+	}
+}
+
+func TestValidateType(t *testing.T) {
+	valid := [][]string{{"app", ""}, {"", ""}, {"library", ""}, {"plugin", "test/app"}}
+	for _, v := range valid {
+		if err := ValidateType("test/pkg", v[0], v[1]); err != nil {
+			t.Error(err)
+		}
+	}
+
+	invalid := [][]string{{"plugin", ""}, {"service", "test/app"}, {"daemon", ""}}
+	for _, v := range invalid {
+		if err := ValidateType("test/pkg", v[0], v[1]); err == nil {
+			t.Error(fmt.Errorf("Type \"%s\" extending \"%s\" should be invalid", v[0], v[1]))
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package pm
+
+import (
+	"fmt"
+)
+
+const (
+	// DecisionExplicit selected a package that has been asked for
+	DecisionExplicit = "explicit"
+	// DecisionDependency selected a package another one depends on or recommends
+	DecisionDependency = "dependency"
+	// DecisionDefault selected the default provider of a virtual package
+	DecisionDefault = "default"
+	// DecisionSatisfied skipped a dependency a selected package already satisfies
+	DecisionSatisfied = "satisfied"
+	// DecisionConflict dropped a package because another one provides the same
+	DecisionConflict = "conflict"
+	// DecisionDuplicate dropped a package that has been asked for twice
+	DecisionDuplicate = "duplicate"
+	// DecisionUnknown skipped a package that no list contains
+	DecisionUnknown = "unknown"
+	// DecisionSkipped skipped a recommendation because recommends are disabled
+	DecisionSkipped = "skipped"
+	// DecisionExcluded skipped a package in "without"
+	DecisionExcluded = "excluded"
+	// DecisionProvider selected the chosen or only provider of a virtual package
+	DecisionProvider = "provider"
+	// DecisionAmbiguous skipped a virtual package with several providers and none chosen
+	DecisionAmbiguous = "ambiguous"
+	// DecisionMismatch skipped a package whose version doesn't match the constraint
+	DecisionMismatch = "mismatch"
+)
+
+// Decision is a step of the resolver
+type Decision struct {
+	Kind    string `json:"kind"`
+	Package string `json:"package"`
+	// By is the package whose dependency led to the decision, empty for explicit ones
+	By string `json:"by,omitempty"`
+	// Dependency is what By depends on, the virtual package for defaults
+	Dependency string `json:"dependency,omitempty"`
+	Recommends bool   `json:"recommends,omitempty"`
+	// Provider is the selected package that already satisfies Dependency
+	Provider string `json:"provider,omitempty"`
+	// Preference is the entry of "prefer" that selected the provider
+	Preference string `json:"preference,omitempty"`
+	// Constraint is the version constraint of the dependency
+	Constraint string `json:"constraint,omitempty"`
+}
+
+// Selected reports if the decision added its package
+func (d Decision) Selected() bool {
+	return d.Kind == DecisionExplicit || d.Kind == DecisionDependency || d.Kind == DecisionDefault || d.Kind == DecisionProvider
+}
+
+func (d Decision) String() string {
+	verb := "depends on"
+	if d.Recommends {
+		verb = "recommends"
+	}
+
+	switch d.Kind {
+	case DecisionExplicit:
+		return fmt.Sprintf("%s is installed explicitly", d.Package)
+	case DecisionDependency:
+		return fmt.Sprintf("%s %s %s", d.By, verb, d.Package)
+	case DecisionDefault:
+		return fmt.Sprintf("%s %s %s, %s is its default provider", d.By, verb, d.Dependency, d.Package)
+	case DecisionProvider:
+		if d.Preference != "" {
+			return fmt.Sprintf("%s %s %s, %s is preferred by \"%s\"", d.By, verb, d.Dependency, d.Package, d.Preference)
+		}
+		return fmt.Sprintf("%s %s %s, %s is its chosen provider", d.By, verb, d.Dependency, d.Package)
+	case DecisionAmbiguous:
+		return fmt.Sprintf("%s %s %s which has several providers and none has been chosen", d.By, verb, d.Package)
+	case DecisionMismatch:
+		if d.Provider != "" {
+			return fmt.Sprintf("%s %s %s %s but %s provides another version", d.By, verb, d.Dependency, d.Constraint, d.Provider)
+		}
+		return fmt.Sprintf("%s %s %s %s but another version is available", d.By, verb, d.Dependency, d.Constraint)
+	case DecisionSatisfied:
+		return fmt.Sprintf("%s %s %s which %s already provides", d.By, verb, d.Dependency, d.Provider)
+	case DecisionConflict:
+		return fmt.Sprintf("%s provides %s which %s already provides", d.Package, d.Dependency, d.Provider)
+	case DecisionDuplicate:
+		return fmt.Sprintf("%s has been asked for more than once", d.Package)
+	case DecisionUnknown:
+		if d.By == "" {
+			return fmt.Sprintf("%s is in none of the lists", d.Package)
+		}
+		return fmt.Sprintf("%s %s %s which is in none of the lists", d.By, verb, d.Package)
+	case DecisionSkipped:
+		return fmt.Sprintf("%s recommends %s but recommends are disabled", d.By, d.Package)
+	case DecisionExcluded:
+		if d.By == "" {
+			return fmt.Sprintf("%s is excluded by \"without\"", d.Package)
+		}
+		return fmt.Sprintf("%s %s %s, %s is excluded by \"without\"", d.By, verb, d.Dependency, d.Package)
+	}
+
+	return fmt.Sprintf("%s: %s", d.Kind, d.Package)
+}
+
+func (pm *PackageManager) record(d Decision) {
+	pm.trace = append(pm.trace, d)
+}
+
+// knownBy returns the selected package that is or provides name
+func (pm *PackageManager) knownBy(name string) string {
+	for _, d := range pm.trace {
+		if !d.Selected() {
+			continue
+		}
+		if d.Package == name {
+			return d.Package
+		}
+		if p, ok := pm.packages[d.Package]; ok {
+			for _, prov := range p.ProvidedNames() {
+				if prov == name {
+					return d.Package
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// Trace returns the decisions of the last GetDependencies
+func (pm *PackageManager) Trace() []Decision {
+	return pm.trace
+}
+
+// RecommendsOnly returns the selected packages that no explicitly installed
+// package needs through dependencies alone
+func (pm *PackageManager) RecommendsOnly() []string {
+	edges := make(map[string][]string)
+	todo := []string{}
+	for _, d := range pm.trace {
+		switch {
+		case d.Kind == DecisionExplicit:
+			todo = append(todo, d.Package)
+		case d.Recommends:
+		case d.Kind == DecisionDependency || d.Kind == DecisionDefault || d.Kind == DecisionProvider:
+			edges[d.By] = append(edges[d.By], d.Package)
+		case d.Kind == DecisionSatisfied && d.Provider != "":
+			edges[d.By] = append(edges[d.By], d.Provider)
+		}
+	}
+
+	needed := make(map[string]bool)
+	for len(todo) > 0 {
+		name := todo[0]
+		todo = todo[1:]
+		if needed[name] {
+			continue
+		}
+		needed[name] = true
+		todo = append(todo, edges[name]...)
+	}
+
+	result := []string{}
+	seen := make(map[string]bool)
+	for _, d := range pm.trace {
+		if d.Selected() && !needed[d.Package] && !seen[d.Package] {
+			seen[d.Package] = true
+			result = append(result, d.Package)
+		}
+	}
+
+	return result
+}
+
+// Preferred returns the decisions that selected a provider by a preference
+func (pm *PackageManager) Preferred() []Decision {
+	result := []Decision{}
+	for _, d := range pm.trace {
+		if d.Selected() && d.Preference != "" {
+			result = append(result, d)
+		}
+	}
+
+	return result
+}
+
+// Why returns the decisions that led from an explicitly installed package
+// to the package name, the explicit one first
+func (pm *PackageManager) Why(name string) ([]Decision, error) {
+	selected := make(map[string]Decision)
+	for _, d := range pm.trace {
+		if _, ok := selected[d.Package]; d.Selected() && !ok {
+			selected[d.Package] = d
+		}
+	}
+
+	target := name
+	if _, ok := selected[target]; !ok {
+		target = pm.knownBy(name)
+	}
+	if target == "" {
+		return nil, fmt.Errorf("Package \"%s\" has not been selected", name)
+	}
+
+	result := []Decision{}
+	seen := make(map[string]bool)
+	for target != "" && !seen[target] {
+		seen[target] = true
+		d := selected[target]
+		result = append([]Decision{d}, result...)
+		target = d.By
+	}
+
+	return result, nil
+}
+
+// WhyNot returns the decisions that kept package name out, there are none if
+// nothing asked for it
+func (pm *PackageManager) WhyNot(name string) ([]Decision, error) {
+	if by := pm.knownBy(name); by == name {
+		return nil, fmt.Errorf("Package \"%s\" has been selected", name)
+	}
+
+	p, known := pm.packages[name]
+	result := []Decision{}
+	for _, d := range pm.trace {
+		if d.Selected() {
+			continue
+		}
+		if d.Package == name || d.Dependency == name {
+			result = append(result, d)
+			continue
+		}
+		// Dependencies on a virtual package name provides
+		if known && d.Kind == DecisionSatisfied {
+			for _, prov := range p.ProvidedNames() {
+				if d.Dependency == prov {
+					result = append(result, d)
+				}
+			}
+		}
+	}
+
+	if !known && len(result) == 0 {
+		result = append(result, Decision{Kind: DecisionUnknown, Package: name})
+	}
+
+	return result, nil
+}
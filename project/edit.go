@@ -0,0 +1,207 @@
+package project
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yamlLines are the lines of a zemm file, they get edited textually to keep
+// comments and formatting
+type yamlLines []string
+
+func splitLines(contents []byte) yamlLines {
+	return strings.Split(string(contents), "\n")
+}
+
+func (l yamlLines) bytes() []byte {
+	return []byte(strings.Join(l, "\n"))
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// isContent reports if line is neither blank nor a comment
+func isContent(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed != "" && !strings.HasPrefix(trimmed, "#")
+}
+
+// section returns the lines [start, end) of the top-level key, start is the
+// line of the key, it is -1 if there is no such key
+func (l yamlLines) section(key string) (int, int) {
+	start := -1
+	for i, line := range l {
+		if strings.HasPrefix(line, key+":") {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		return -1, -1
+	}
+
+	end := start + 1
+	for i := start + 1; i < len(l); i++ {
+		if !isContent(l[i]) {
+			continue
+		}
+		// Block sequences may start at the indentation of the key
+		if indentOf(l[i]) == 0 && !strings.HasPrefix(l[i], "-") {
+			break
+		}
+		end = i + 1
+	}
+
+	return start, end
+}
+
+// inline reports if the section starting at line start has its value on the
+// same line, like "install: []"
+func (l yamlLines) inline(start int) bool {
+	value := strings.SplitN(l[start], ":", 2)[1]
+	value = strings.TrimSpace(strings.SplitN(value, "#", 2)[0])
+	return value != ""
+}
+
+// splice replaces the lines [start, end) with lines
+func (l yamlLines) splice(start, end int, lines ...string) yamlLines {
+	result := append(yamlLines{}, l[:start]...)
+	result = append(result, lines...)
+	return append(result, l[end:]...)
+}
+
+// marshalLines marshals value into lines indented by indent spaces
+func marshalLines(value interface{}, indent int) ([]string, error) {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		result = append(result, strings.Repeat(" ", indent)+line)
+	}
+	return result, nil
+}
+
+// setSection replaces the top-level key with value or appends it, the other
+// sections are kept as they are
+func (l yamlLines) setSection(key string, value interface{}) (yamlLines, error) {
+	lines, err := marshalLines(value, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 1 && (strings.HasPrefix(lines[0], "  [") || strings.HasPrefix(lines[0], "  {")) {
+		lines = []string{key + ": " + strings.TrimSpace(lines[0])}
+	} else {
+		lines = append([]string{key + ":"}, lines...)
+	}
+
+	start, end := l.section(key)
+	if start == -1 {
+		for len(l) > 0 && l[len(l)-1] == "" {
+			l = l[:len(l)-1]
+		}
+		if len(l) > 0 {
+			l = append(l, "")
+		}
+		return append(append(l, lines...), ""), nil
+	}
+
+	return l.splice(start, end, lines...), nil
+}
+
+// item is an entry of a block sequence, start includes the comments in front of it
+type item struct {
+	start, dash, end int
+}
+
+// items returns the entries of the block sequence of the section [start, end)
+// and their indentation
+func (l yamlLines) items(start, end int) ([]item, int) {
+	result := []item{}
+	indent := -1
+	comments := -1
+	for i := start + 1; i < end; i++ {
+		if !isContent(l[i]) {
+			if comments == -1 && strings.TrimSpace(l[i]) != "" {
+				comments = i
+			}
+			if strings.TrimSpace(l[i]) == "" {
+				comments = -1
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(l[i])
+		if indent == -1 && strings.HasPrefix(trimmed, "-") {
+			indent = indentOf(l[i])
+		}
+		if indentOf(l[i]) == indent && strings.HasPrefix(trimmed, "-") {
+			first := i
+			if comments != -1 {
+				first = comments
+			}
+			if len(result) > 0 {
+				result[len(result)-1].end = first
+			}
+			result = append(result, item{start: first, dash: i, end: end})
+		}
+		comments = -1
+	}
+
+	return result, indent
+}
+
+// unmarshalItem decodes the entry it of a sequence indented by indent into out
+func (l yamlLines) unmarshalItem(it item, indent int, out interface{}) error {
+	lines := []string{}
+	for _, line := range l[it.dash:it.end] {
+		if indentOf(line) >= indent {
+			line = line[indent:]
+		} else {
+			line = strings.TrimLeft(line, " ")
+		}
+		lines = append(lines, line)
+	}
+
+	return yaml.Unmarshal([]byte(strings.Join(lines, "\n")), out)
+}
+
+// mapKey returns the key of the line of a block mapping
+func mapKey(line string) (interface{}, bool) {
+	m := yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(strings.TrimSpace(line)), &m); err != nil || len(m) != 1 {
+		return nil, false
+	}
+	return m[0].Key, true
+}
+
+// listLine matches a list reference of the "lists" section like "main: x"
+// or "- list: x", quoted or not and with an optional comment
+var listLine = regexp.MustCompile(`^(\s*(?:-\s+)?(?:main|list):\s*)(["']?)([^\s"'#]+)(["']?)(\s*(?:#.*)?)$`)
+
+// replaceLists replaces the list references in the "lists" section that are
+// exactly old (old => new), each reference gets replaced at most once
+func (l yamlLines) replaceLists(replacements map[string]string) yamlLines {
+	start, end := l.section("lists")
+	if start == -1 {
+		return l
+	}
+
+	result := append(yamlLines{}, l...)
+	for i := start + 1; i < end; i++ {
+		m := listLine.FindStringSubmatch(l[i])
+		if m == nil || m[2] != m[4] {
+			continue
+		}
+		if new, ok := replacements[m[3]]; ok {
+			result[i] = m[1] + m[2] + new + m[4] + m[5]
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,558 @@
+package project
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/pm"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FileName is the name of the zemm file in a project directory
+	FileName = "zemm.yaml"
+	// LocalFileName is the name of the local override file
+	LocalFileName = "local.zemm.yaml"
+	// DefaultIndex is the index name lists and packages get downloaded from
+	DefaultIndex = "zemm"
+)
+
+type Lists struct {
+	Main       string             `json:"main,omitempty" yaml:"main,omitempty"`
+	Additional []pm.ListOrPackage `json:"additional,omitempty" yaml:"additional,omitempty"`
+}
+
+type Install struct {
+	Package string `json:"package" yaml:"package"`
+	Overlay bool   `json:"overlay,omitempty" yaml:"overlay,omitempty"`
+	// Recommends overrides if the recommended packages of the package get installed
+	Recommends *bool `json:"recommends,omitempty" yaml:"recommends,omitempty"`
+}
+
+type Clear struct {
+	ListsAdditional bool `json:"lists_additional,omitempty" yaml:"lists_additional,omitempty"`
+	// AdditionalLists is an alias for ListsAdditional
+	AdditionalLists bool `json:"additional_lists,omitempty" yaml:"additional_lists,omitempty"`
+	Install         bool `json:"install,omitempty" yaml:"install,omitempty"`
+}
+
+// Settings are per package settings, package name => key => value
+type Settings map[string]map[string]interface{}
+
+// ReservedSettings are settings keys which are not variables
+var ReservedSettings = []string{"environment", "ports", "volumes"}
+
+type File struct {
+	Version      string            `json:"version" yaml:"version"`
+	Indexes      map[string]string `json:"indexes,omitempty" yaml:"indexes,omitempty"`
+	Repositories map[string]string `json:"repositories,omitempty" yaml:"repositories,omitempty"`
+	Clear        Clear             `json:"clear,omitempty" yaml:"clear,omitempty"`
+	Lists        Lists             `json:"lists" yaml:"lists"`
+	Install      []Install         `json:"install" yaml:"install"`
+	Settings     Settings          `json:"settings,omitempty" yaml:"settings,omitempty"`
+	// Without are packages that never get installed as dependency or recommendation
+	Without []string `json:"without,omitempty" yaml:"without,omitempty"`
+	// Providers are the chosen providers of virtual packages, virtual package => provider
+	Providers map[string]string `json:"providers,omitempty" yaml:"providers,omitempty"`
+	// Prefer are preferred providers, names or patterns like "*_pgsql", the first matching wins
+	Prefer []string `json:"prefer,omitempty" yaml:"prefer,omitempty"`
+	// KeepGenerations is the number of previous installs kept for rollbacks
+	KeepGenerations int `json:"keep_generations,omitempty" yaml:"keep_generations,omitempty"`
+}
+
+type Project struct {
+	dir       string
+	Indexes   map[string]string
+	Lists     Lists
+	Install   []Install
+	Overrides map[string]string
+	Settings  Settings
+	Without   []string
+	Providers map[string]string
+	Prefer    []string
+	// KeepGenerations is 0 for the default
+	KeepGenerations int
+}
+
+// Load reads the zemm.yaml of dir and applies local.zemm.yaml if there is one
+func Load(dir string) (*Project, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Project{
+		dir: absDir,
+		Indexes: map[string]string{
+			"zemm":   "https://hub.zemm.io",
+			"docker": "https://hub.docker.com",
+		},
+		Overrides: make(map[string]string),
+		Settings:  Settings{},
+		Providers: make(map[string]string),
+	}
+
+	main := &File{}
+	if err := common.URLToStruct(path.Join(absDir, FileName), main); err != nil {
+		return nil, err
+	}
+	p.apply(main)
+
+	if common.FileExists(path.Join(absDir, LocalFileName)) {
+		local := &File{}
+		if err := common.URLToStruct(path.Join(absDir, LocalFileName), local); err != nil {
+			return nil, err
+		}
+		p.apply(local)
+	}
+
+	return p, p.parseOverrides()
+}
+
+func (p *Project) apply(f *File) {
+	for k, v := range f.Indexes {
+		p.Indexes[k] = p.resolveIndex(v)
+	}
+	for k, v := range f.Repositories {
+		p.Indexes[k] = p.resolveIndex(v)
+	}
+
+	if f.Lists.Main != "" {
+		p.Lists.Main = f.Lists.Main
+	}
+
+	if f.Clear.ListsAdditional || f.Clear.AdditionalLists {
+		p.Lists.Additional = []pm.ListOrPackage{}
+	}
+	p.Lists.Additional = append(p.Lists.Additional, f.Lists.Additional...)
+
+	if f.Clear.Install {
+		p.Install = []Install{}
+	}
+	p.Install = append(p.Install, f.Install...)
+	p.Without = append(p.Without, f.Without...)
+	for k, v := range f.Providers {
+		p.Providers[k] = v
+	}
+	// Preferences of the local file come first
+	p.Prefer = append(append([]string{}, f.Prefer...), p.Prefer...)
+
+	if f.KeepGenerations != 0 {
+		p.KeepGenerations = f.KeepGenerations
+	}
+
+	for pkg, values := range f.Settings {
+		if _, ok := p.Settings[pkg]; !ok {
+			p.Settings[pkg] = make(map[string]interface{})
+		}
+		for k, v := range values {
+			p.Settings[pkg][k] = v
+		}
+	}
+}
+
+// resolveIndex makes relative index paths relative to the project directory
+func (p *Project) resolveIndex(index string) string {
+	if common.URLIsValidAndHTTP(index) || path.IsAbs(index) {
+		return index
+	}
+
+	return path.Join(p.dir, index)
+}
+
+// parseOverrides collects "package@version" entries from the additional lists
+func (p *Project) parseOverrides() error {
+	result := &multierror.Error{}
+
+	for _, lp := range p.Lists.Additional {
+		if lp.Package == "" {
+			continue
+		}
+
+		exp := strings.SplitN(lp.Package, "@", 2)
+		if len(exp) != 2 || exp[0] == "" || exp[1] == "" {
+			result = multierror.Append(result, fmt.Errorf("Additional package \"%s\" must be in the form \"namespace/package@version\"", lp.Package))
+			continue
+		}
+		p.Overrides[exp[0]] = exp[1]
+	}
+
+	return result.ErrorOrNil()
+}
+
+// Dir returns the absolute path of the project directory
+func (p *Project) Dir() string {
+	return p.dir
+}
+
+// Index returns the location of the index with the given name
+func (p *Project) Index(name string) (string, error) {
+	i, ok := p.Indexes[name]
+	if !ok || i == "" {
+		return "", fmt.Errorf("Unknown index \"%s\"", name)
+	}
+
+	return i, nil
+}
+
+// AllLists returns the main list followed by all additional lists
+func (p *Project) AllLists() []string {
+	result := []string{}
+	if p.Lists.Main != "" {
+		result = append(result, p.Lists.Main)
+	}
+	for _, lp := range p.Lists.Additional {
+		if lp.List != "" {
+			result = append(result, lp.List)
+		}
+	}
+
+	return result
+}
+
+// InstallNames returns the names of all packages to install
+func (p *Project) InstallNames() []string {
+	result := make([]string, len(p.Install))
+	for i, inst := range p.Install {
+		result[i] = inst.Package
+	}
+
+	return result
+}
+
+// ReplaceList replaces the list reference old with new, it returns false
+// if the project doesn't use old
+func (p *Project) ReplaceList(old, new string) bool {
+	found := false
+
+	if p.Lists.Main == old {
+		p.Lists.Main = new
+		found = true
+	}
+	for i, lp := range p.Lists.Additional {
+		if lp.List == old {
+			p.Lists.Additional[i].List = new
+			found = true
+		}
+	}
+
+	return found
+}
+
+// Files returns the zemm files of the project that exist
+func (p *Project) Files() []string {
+	result := []string{}
+	for _, f := range []string{FileName, LocalFileName} {
+		if common.FileExists(path.Join(p.dir, f)) {
+			result = append(result, path.Join(p.dir, f))
+		}
+	}
+
+	return result
+}
+
+// WriteLists replaces the list references (old => new) in "lists" of the
+// zemm files, only whole entries get replaced and comments and formatting are kept
+func (p *Project) WriteLists(replacements map[string]string) error {
+	for _, f := range p.Files() {
+		contents, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+
+		changed := splitLines(contents).replaceLists(replacements).bytes()
+		if string(changed) == string(contents) {
+			continue
+		}
+
+		if err := ioutil.WriteFile(f, changed, common.OS_USER_RW|common.OS_GROUP_R|common.OS_OTH_R); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Snapshot holds the contents of the zemm files to restore them later
+type Snapshot map[string][]byte
+
+// Snapshot saves the contents of all zemm files of the project
+func (p *Project) Snapshot() (Snapshot, error) {
+	s := Snapshot{}
+	for _, f := range p.Files() {
+		contents, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		s[f] = contents
+	}
+
+	return s, nil
+}
+
+// Restore writes the saved contents back
+func (s Snapshot) Restore() error {
+	result := &multierror.Error{}
+	for f, contents := range s {
+		if err := ioutil.WriteFile(f, contents, common.OS_USER_RW|common.OS_GROUP_R|common.OS_OTH_R); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// Vars returns the scalar settings of package pkg, those can be used
+// as variables in the compose fragment of the package
+func (s Settings) Vars(pkg string) map[string]string {
+	result := make(map[string]string)
+
+	for k, v := range s[pkg] {
+		reserved := false
+		for _, r := range ReservedSettings {
+			if k == r {
+				reserved = true
+				break
+			}
+		}
+		if reserved {
+			continue
+		}
+
+		switch v.(type) {
+		case string, int, int64, float64, bool:
+			result[k] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	return result
+}
+
+// Value returns the scalar setting key of package pkg, falling back to
+// the environment setting of the same name
+func (s Settings) Value(pkg, key string) interface{} {
+	if v, ok := s[pkg][key]; ok && key != "environment" {
+		return v
+	}
+	if v, ok := s.Map(pkg, "environment")[key]; ok {
+		return v
+	}
+
+	return nil
+}
+
+// Map returns the map setting key of package pkg
+func (s Settings) Map(pkg, key string) map[string]string {
+	result := make(map[string]string)
+
+	switch m := s[pkg][key].(type) {
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			result[fmt.Sprintf("%v", k)] = fmt.Sprintf("%v", v)
+		}
+	case map[string]interface{}:
+		for k, v := range m {
+			result[k] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	return result
+}
+
+// List returns the list setting key of package pkg
+func (s Settings) List(pkg, key string) []string {
+	result := []string{}
+
+	if l, ok := s[pkg][key].([]interface{}); ok {
+		for _, v := range l {
+			result = append(result, fmt.Sprintf("%v", v))
+		}
+	}
+
+	return result
+}
+
+// AddInstall adds inst to the packages to install and writes it to the
+// zemm.yaml, an already installed package gets its overlay flag updated
+func (p *Project) AddInstall(inst Install) error {
+	found := false
+	for i := range p.Install {
+		if p.Install[i].Package == inst.Package {
+			p.Install[i].Overlay = inst.Overlay
+			found = true
+		}
+	}
+	if !found {
+		p.Install = append(p.Install, inst)
+	}
+
+	return p.editFile(func(f *File, l yamlLines) (yamlLines, error) {
+		start, end := l.section("install")
+		items, indent := []item{}, 0
+		if start != -1 && !l.inline(start) {
+			items, indent = l.items(start, end)
+		}
+
+		for _, it := range items {
+			existing := []Install{}
+			if err := l.unmarshalItem(it, indent, &existing); err != nil {
+				return nil, err
+			}
+			if len(existing) != 1 || existing[0].Package != inst.Package {
+				continue
+			}
+			if existing[0].Overlay == inst.Overlay {
+				return l, nil
+			}
+
+			// The comments of the entry are kept, its values are replaced
+			existing[0].Overlay = inst.Overlay
+			lines, err := marshalLines(existing, indent)
+			if err != nil {
+				return nil, err
+			}
+			kept := []string{}
+			for _, line := range l[it.dash:it.end] {
+				if !isContent(line) {
+					kept = append(kept, line)
+				}
+			}
+			return l.splice(it.dash, it.end, append(lines, kept...)...), nil
+		}
+
+		if len(items) == 0 {
+			return l.setSection("install", append(f.Install, inst))
+		}
+		lines, err := marshalLines([]Install{inst}, indent)
+		if err != nil {
+			return nil, err
+		}
+		return l.splice(end, end, lines...), nil
+	})
+}
+
+// RemoveInstall removes package name from the packages to install and from
+// the zemm.yaml, packages only installed by local.zemm.yaml can't be removed
+func (p *Project) RemoveInstall(name string) error {
+	err := p.editFile(func(f *File, l yamlLines) (yamlLines, error) {
+		start, end := l.section("install")
+		if start != -1 && !l.inline(start) {
+			items, indent := l.items(start, end)
+			for _, it := range items {
+				existing := []Install{}
+				if err := l.unmarshalItem(it, indent, &existing); err != nil {
+					return nil, err
+				}
+				if len(existing) != 1 || existing[0].Package != name {
+					continue
+				}
+				if len(items) == 1 {
+					return l.splice(start, end, "install: []"), nil
+				}
+				return l.splice(it.start, it.end), nil
+			}
+		}
+
+		result := []Install{}
+		for _, inst := range f.Install {
+			if inst.Package != name {
+				result = append(result, inst)
+			}
+		}
+		if len(result) == len(f.Install) {
+			return nil, fmt.Errorf("Package \"%s\" is not in \"install\" of %s", name, FileName)
+		}
+		return l.setSection("install", result)
+	})
+	if err != nil {
+		return err
+	}
+
+	result := []Install{}
+	for _, inst := range p.Install {
+		if inst.Package != name {
+			result = append(result, inst)
+		}
+	}
+	p.Install = result
+
+	return nil
+}
+
+// SetProvider chooses provider for the virtual package name and writes it to
+// "providers" of the zemm.yaml
+func (p *Project) SetProvider(name, provider string) error {
+	err := p.editFile(func(f *File, l yamlLines) (yamlLines, error) {
+		entry := yaml.MapSlice{{Key: name, Value: provider}}
+
+		start, end := l.section("providers")
+		if start == -1 || l.inline(start) {
+			providers := yaml.MapSlice{}
+			keys := []string{}
+			for k := range f.Providers {
+				if k != name {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				providers = append(providers, yaml.MapItem{Key: k, Value: f.Providers[k]})
+			}
+			return l.setSection("providers", append(providers, entry...))
+		}
+
+		indent := 2
+		for i := start + 1; i < end; i++ {
+			if !isContent(l[i]) {
+				continue
+			}
+			indent = indentOf(l[i])
+			if key, ok := mapKey(l[i]); ok && key == name {
+				lines, err := marshalLines(entry, indent)
+				if err != nil {
+					return nil, err
+				}
+				return l.splice(i, i+1, lines...), nil
+			}
+		}
+		lines, err := marshalLines(entry, indent)
+		if err != nil {
+			return nil, err
+		}
+		return l.splice(end, end, lines...), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.Providers[name] = provider
+	return nil
+}
+
+// editFile changes the lines of the zemm.yaml with edit, f is the parsed file,
+// everything edit doesn't change is kept including comments
+func (p *Project) editFile(edit func(f *File, l yamlLines) (yamlLines, error)) error {
+	f := path.Join(p.dir, FileName)
+	contents, err := ioutil.ReadFile(f)
+	if err != nil {
+		return err
+	}
+
+	file := &File{}
+	if err := yaml.Unmarshal(contents, file); err != nil {
+		return err
+	}
+
+	lines, err := edit(file, splitLines(contents))
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(f, lines.bytes(), common.OS_USER_RW|common.OS_GROUP_R|common.OS_OTH_R)
+}
@@ -0,0 +1,187 @@
+package project
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMinadmin(t *testing.T) {
+	p, err := Load("../examples/apps/minadmin")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if p.Lists.Main != "minadmin/minadmin/1.0.0" {
+		t.Error(fmt.Errorf("Invalid main list: %s", p.Lists.Main))
+	}
+
+	repo, _ := filepath.Abs("../examples/repo")
+	if i, _ := p.Index(DefaultIndex); i != repo {
+		t.Error(fmt.Errorf("Invalid zemm index: %s, expected %s", i, repo))
+	}
+
+	if v := p.Overrides["tuatzemm/abac_pgsql"]; v != "6.6.6" {
+		t.Error(fmt.Errorf("Invalid override for tuatzemm/abac_pgsql: %s", v))
+	}
+
+	if len(p.Install) != 1 || p.Install[0].Package != "minadmin/minadmin_pgsql" || !p.Install[0].Overlay {
+		t.Error(fmt.Errorf("Invalid install: %v", p.Install))
+	}
+
+	if _, ok := p.Settings["tuatzemm/orch-docker"]["environment"]; !ok {
+		t.Error(fmt.Errorf("Missing settings of tuatzemm/orch-docker"))
+	}
+	if vars := p.Settings.Vars("tuatzemm/orch-docker"); len(vars) != 0 {
+		t.Error(fmt.Errorf("Reserved settings must not be variables: %v", vars))
+	}
+}
+
+func TestSetProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := "version: 1.0\nlists:\n  main: minadmin/minadmin/1.0.0\ninstall:\n  - package: tuatzemm/auth\nproviders:\n  tuatzemm/abac: tuatzemm/abac_pgsql\n"
+	if err := ioutil.WriteFile(path.Join(dir, FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetProvider("tuatzemm/settings", "tuatzemm/settings_pgsql"); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Providers["tuatzemm/settings"] != "tuatzemm/settings_pgsql" || p.Providers["tuatzemm/abac"] != "tuatzemm/abac_pgsql" {
+		t.Error(fmt.Errorf("Invalid providers: %v", p.Providers))
+	}
+	if len(p.Install) != 1 || p.Lists.Main != "minadmin/minadmin/1.0.0" {
+		t.Error(fmt.Errorf("Choosing a provider changed other sections: %v", p))
+	}
+}
+
+func TestEditKeepsComments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	contents, err := ioutil.ReadFile("../examples/apps/minadmin/zemm.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, FileName), contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetProvider("tuatzemm/settings", "tuatzemm/settings_pgsql"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddInstall(Install{Package: "library/nats"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddInstall(Install{Package: "library/nats", Overlay: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RemoveInstall("minadmin/minadmin_pgsql"); err != nil {
+		t.Fatal(err)
+	}
+
+	edited, err := ioutil.ReadFile(path.Join(dir, FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"version: 1.0", "# Both are the default no need to define them", "  main: minadmin/minadmin/1.0.0"} {
+		if !strings.Contains(string(edited), line+"\n") {
+			t.Error(fmt.Errorf("\"%s\" got lost:\n%s", line, edited))
+		}
+	}
+	if strings.Contains(string(edited), "# This allows the package above") {
+		t.Error(fmt.Errorf("The comments of the removed package are still there:\n%s", edited))
+	}
+
+	p, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Install) != 1 || p.Install[0].Package != "library/nats" || !p.Install[0].Overlay {
+		t.Error(fmt.Errorf("Invalid install: %v", p.Install))
+	}
+	if p.Providers["tuatzemm/settings"] != "tuatzemm/settings_pgsql" {
+		t.Error(fmt.Errorf("Invalid providers: %v", p.Providers))
+	}
+}
+
+func TestWriteLists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := `version: 1.0
+# Upgraded from tuatzemm/suite/1.0.1
+lists:
+  main: tuatzemm/suite/1.0.1 # the suite
+  additional:
+    - list: "tuatzemm/extra/1.0.10"
+    - list: tuatzemm/extra/1.0.1
+    - package: tuatzemm/auth@1.0.1
+install:
+  - package: tuatzemm/suite/1.0.1
+`
+	if err := ioutil.WriteFile(path.Join(dir, FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.WriteLists(map[string]string{
+		"tuatzemm/suite/1.0.1":  "tuatzemm/suite/1.0.2",
+		"tuatzemm/extra/1.0.1":  "tuatzemm/extra/1.0.10",
+		"tuatzemm/extra/1.0.10": "tuatzemm/extra/1.0.11",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `version: 1.0
+# Upgraded from tuatzemm/suite/1.0.1
+lists:
+  main: tuatzemm/suite/1.0.2 # the suite
+  additional:
+    - list: "tuatzemm/extra/1.0.11"
+    - list: tuatzemm/extra/1.0.10
+    - package: tuatzemm/auth@1.0.1
+install:
+  - package: tuatzemm/suite/1.0.1
+`
+	written, err := ioutil.ReadFile(path.Join(dir, FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != expected {
+		t.Error(fmt.Errorf("Invalid list replacement:\n%s", written))
+	}
+}
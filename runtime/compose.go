@@ -0,0 +1,119 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// ComposeCLI is the Runtime that uses the docker-compose and docker command line
+type ComposeCLI struct {
+	// Dir is the directory containing the docker-compose.yaml
+	Dir string
+	// Command is the docker-compose executable, defaults to "docker-compose"
+	Command string
+	// Docker is the docker executable, defaults to "docker"
+	Docker string
+}
+
+func NewComposeCLI(dir string) *ComposeCLI {
+	return &ComposeCLI{Dir: dir, Command: "docker-compose", Docker: "docker"}
+}
+
+func (c *ComposeCLI) run(stdout io.Writer, args ...string) error {
+	cmdRun := exec.Command(c.Command, args...)
+	cmdRun.Dir = c.Dir
+	cmdRun.Stdout = stdout
+	cmdRun.Stderr = os.Stderr
+
+	return cmdRun.Run()
+}
+
+func (c *ComposeCLI) Up(services ...string) error {
+	return c.run(os.Stdout, append([]string{"up", "-d"}, services...)...)
+}
+
+func (c *ComposeCLI) Down() error {
+	return c.run(os.Stdout, "down")
+}
+
+func (c *ComposeCLI) Stop(services ...string) error {
+	return c.run(os.Stdout, append([]string{"stop"}, services...)...)
+}
+
+func (c *ComposeCLI) Remove(services ...string) error {
+	return c.run(os.Stdout, append([]string{"rm", "-f"}, services...)...)
+}
+
+func (c *ComposeCLI) Pull(services ...string) error {
+	return c.run(os.Stdout, append([]string{"pull"}, services...)...)
+}
+
+func (c *ComposeCLI) Logs(w io.Writer, follow bool, services ...string) error {
+	args := []string{"logs"}
+	if follow {
+		args = append(args, "-f")
+	}
+
+	return c.run(w, append(args, services...)...)
+}
+
+// dockerPsLine is a line of "docker ps --format '{{json .}}'"
+type dockerPsLine struct {
+	ID     string `json:"ID"`
+	Image  string `json:"Image"`
+	Names  string `json:"Names"`
+	State  string `json:"State"`
+	Status string `json:"Status"`
+	Labels string `json:"Labels"`
+}
+
+// parseDockerPs parses the output of "docker ps --format '{{json .}}'"
+func parseDockerPs(out []byte) ([]Container, error) {
+	result := []Container{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		l := dockerPsLine{}
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			return nil, err
+		}
+
+		c := Container{ID: l.ID, Name: l.Names, Image: l.Image, State: l.State, Status: l.Status}
+		for _, label := range strings.Split(l.Labels, ",") {
+			kv := strings.SplitN(label, "=", 2)
+			if len(kv) == 2 && kv[0] == LabelService {
+				c.Service = kv[1]
+			}
+		}
+		result = append(result, c)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, scanner.Err()
+}
+
+func (c *ComposeCLI) Ps() ([]Container, error) {
+	cmdRun := exec.Command(c.Docker, "ps", "-a", "--no-trunc", "--filter", "label="+LabelProject+"="+ProjectName(c.Dir), "--format", "{{json .}}")
+	cmdRun.Stderr = os.Stderr
+
+	out, err := cmdRun.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDockerPs(out)
+}
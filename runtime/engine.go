@@ -0,0 +1,741 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// LabelNetwork is the label with the compose network name of a network
+	LabelNetwork = "com.docker.compose.network"
+	// LabelConfigHash marks containers with the hash of their configuration
+	LabelConfigHash = "io.zemm.config-hash"
+	// DefaultDockerHost is used when DOCKER_HOST isn't set
+	DefaultDockerHost = "unix:///var/run/docker.sock"
+)
+
+// Engine is the Runtime that talks to the Docker Engine API directly, it
+// supports the subset of the compose file zemm generates: image, command,
+// environment, ports, volumes, networks, depends_on and links
+type Engine struct {
+	// Dir is the directory containing the docker-compose.yaml
+	Dir string
+	// Project is the compose project name containers get labeled with
+	Project string
+
+	client *http.Client
+	base   string
+}
+
+// NewEngine connects to the engine at DOCKER_HOST (unix:// or tcp://)
+func NewEngine(dir, project string) (*Engine, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = DefaultDockerHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid DOCKER_HOST \"%s\": %v", host, err)
+	}
+
+	e := &Engine{Dir: dir, Project: project}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		e.client = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}}
+		e.base = "http://docker"
+	case "tcp", "http":
+		e.client = &http.Client{}
+		e.base = "http://" + u.Host
+	default:
+		return nil, fmt.Errorf("Unsupported DOCKER_HOST scheme \"%s\"", u.Scheme)
+	}
+
+	return e, nil
+}
+
+// NewEngineWithClient uses client to talk to the engine at base, for tests
+func NewEngineWithClient(dir, project, base string, client *http.Client) *Engine {
+	return &Engine{Dir: dir, Project: project, client: client, base: base}
+}
+
+func (e *Engine) request(method, p string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := e.base + p
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusNotModified {
+		defer res.Body.Close()
+		msg := struct {
+			Message string `json:"message"`
+		}{}
+		data, _ := ioutil.ReadAll(res.Body)
+		if json.Unmarshal(data, &msg) != nil || msg.Message == "" {
+			msg.Message = string(data)
+		}
+		return nil, &engineError{method: method, path: p, message: msg.Message, status: res.Status, code: res.StatusCode}
+	}
+
+	return res, nil
+}
+
+// engineError is an error response of the Engine API
+type engineError struct {
+	method, path, message, status string
+	code                          int
+}
+
+func (e *engineError) Error() string {
+	return fmt.Sprintf("%s %s: %s (%s)", e.method, e.path, e.message, e.status)
+}
+
+// isNoSuchImage reports if err is the engine not knowing an image
+func isNoSuchImage(err error) bool {
+	e, ok := err.(*engineError)
+	return ok && e.code == http.StatusNotFound && strings.HasPrefix(e.message, "No such image")
+}
+
+func (e *Engine) do(method, p string, query url.Values, body interface{}, out interface{}) error {
+	res, err := e.request(method, p, query, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(ioutil.Discard, res.Body)
+		return err
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func (e *Engine) labelFilter(labels ...string) url.Values {
+	f, _ := json.Marshal(map[string][]string{"label": append([]string{LabelProject + "=" + e.Project}, labels...)})
+	return url.Values{"filters": []string{string(f)}}
+}
+
+type engineContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+}
+
+func (e *Engine) containers() ([]engineContainer, error) {
+	result := []engineContainer{}
+
+	q := e.labelFilter()
+	q.Set("all", "1")
+	if err := e.do(http.MethodGet, "/containers/json", q, nil, &result); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Labels[LabelService] < result[j].Labels[LabelService]
+	})
+	return result, nil
+}
+
+// selected returns the containers of services, all containers if services is empty
+func (e *Engine) selected(services []string) ([]engineContainer, error) {
+	all, err := e.containers()
+	if err != nil || len(services) == 0 {
+		return all, err
+	}
+
+	want := make(map[string]bool)
+	for _, s := range services {
+		want[s] = true
+	}
+
+	result := []engineContainer{}
+	for _, c := range all {
+		if want[c.Labels[LabelService]] {
+			result = append(result, c)
+		}
+	}
+
+	return result, nil
+}
+
+func (e *Engine) Ps() ([]Container, error) {
+	all, err := e.containers()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Container{}
+	for _, c := range all {
+		name := ""
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		result = append(result, Container{
+			ID:      c.ID,
+			Name:    name,
+			Service: c.Labels[LabelService],
+			Image:   c.Image,
+			State:   c.State,
+			Status:  c.Status,
+		})
+	}
+
+	return result, nil
+}
+
+func (e *Engine) Stop(services ...string) error {
+	containers, err := e.selected(services)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range containers {
+		if c.State != "running" {
+			continue
+		}
+		if err := e.do(http.MethodPost, "/containers/"+c.ID+"/stop", nil, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) Remove(services ...string) error {
+	containers, err := e.selected(services)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range containers {
+		if err := e.do(http.MethodDelete, "/containers/"+c.ID, nil, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) Down() error {
+	if err := e.Stop(); err != nil {
+		return err
+	}
+	if err := e.Remove(); err != nil {
+		return err
+	}
+
+	networks := []struct {
+		ID string `json:"Id"`
+	}{}
+	if err := e.do(http.MethodGet, "/networks", e.labelFilter(), nil, &networks); err != nil {
+		return err
+	}
+	for _, n := range networks {
+		if err := e.do(http.MethodDelete, "/networks/"+n.ID, nil, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) Pull(services ...string) error {
+	f, err := e.composeFile()
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		services = f.names()
+	}
+
+	for _, s := range services {
+		svc, ok := f.Services[s]
+		if !ok {
+			return fmt.Errorf("Unknown service \"%s\"", s)
+		}
+		if err := e.pull(svc.Image); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) pull(image string) error {
+	res, err := e.request(http.MethodPost, "/images/create", url.Values{"fromImage": []string{image}}, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// The engine streams progress messages, errors are part of the stream
+	dec := json.NewDecoder(res.Body)
+	for {
+		msg := struct {
+			Error string `json:"error"`
+		}{}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("Failed to pull %s: %s", image, msg.Error)
+		}
+	}
+}
+
+// prefixWriter prefixes every line written to w
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if _, err := fmt.Fprintf(p.w, "%s | %s\n", p.prefix, scanner.Text()); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}
+
+// demux copies a multiplexed engine log stream to w
+func demux(w io.Writer, r io.Reader) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *Engine) Logs(w io.Writer, follow bool, services ...string) error {
+	containers, err := e.selected(services)
+	if err != nil {
+		return err
+	}
+
+	q := url.Values{"stdout": []string{"1"}, "stderr": []string{"1"}}
+	if follow {
+		q.Set("follow", "1")
+	}
+
+	mu := &sync.Mutex{}
+	errs := make(chan error, len(containers))
+	wg := sync.WaitGroup{}
+	for _, c := range containers {
+		wg.Add(1)
+		go func(c engineContainer) {
+			defer wg.Done()
+
+			res, err := e.request(http.MethodGet, "/containers/"+c.ID+"/logs", q, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer res.Body.Close()
+
+			errs <- demux(&prefixWriter{mu: mu, w: w, prefix: c.Labels[LabelService]}, res.Body)
+		}(c)
+
+		if !follow {
+			// Keep the output of the services together
+			wg.Wait()
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	result := &multierror.Error{}
+	for err := range errs {
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+type composeService struct {
+	Image       string        `yaml:"image"`
+	Command     interface{}   `yaml:"command"`
+	Environment interface{}   `yaml:"environment"`
+	Ports       []interface{} `yaml:"ports"`
+	Volumes     []string      `yaml:"volumes"`
+	Networks    interface{}   `yaml:"networks"`
+	DependsOn   interface{}   `yaml:"depends_on"`
+	Links       []string      `yaml:"links"`
+}
+
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+}
+
+func (f *composeFile) names() []string {
+	result := []string{}
+	for n := range f.Services {
+		result = append(result, n)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func (e *Engine) composeFile() (*composeFile, error) {
+	data, err := ioutil.ReadFile(path.Join(e.Dir, "docker-compose.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	f := &composeFile{}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// keysOrList returns the keys of a map or the items of a list
+func keysOrList(v interface{}) []string {
+	result := []string{}
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		for k := range t {
+			result = append(result, fmt.Sprintf("%v", k))
+		}
+	case []interface{}:
+		for _, i := range t {
+			result = append(result, fmt.Sprintf("%v", i))
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func (s composeService) env() []string {
+	result := []string{}
+	switch t := s.Environment.(type) {
+	case map[interface{}]interface{}:
+		for k, v := range t {
+			result = append(result, fmt.Sprintf("%v=%v", k, v))
+		}
+	case []interface{}:
+		for _, i := range t {
+			result = append(result, fmt.Sprintf("%v", i))
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func (s composeService) cmd() []string {
+	switch t := s.Command.(type) {
+	case string:
+		return strings.Fields(t)
+	case []interface{}:
+		result := []string{}
+		for _, i := range t {
+			result = append(result, fmt.Sprintf("%v", i))
+		}
+		return result
+	}
+
+	return nil
+}
+
+func (e *Engine) containerName(service string) string {
+	return fmt.Sprintf("%s_%s_1", e.Project, service)
+}
+
+// scopedName returns the name of a network or volume in the project
+func (e *Engine) scopedName(name string) string {
+	return fmt.Sprintf("%s_%s", e.Project, name)
+}
+
+// createBody returns the body of a container create request for the service
+func (e *Engine) createBody(name string, s composeService) map[string]interface{} {
+	exposed := map[string]interface{}{}
+	bindings := map[string][]map[string]string{}
+	for _, p := range s.Ports {
+		parts := strings.Split(fmt.Sprintf("%v", p), ":")
+		container := parts[len(parts)-1]
+		if !strings.Contains(container, "/") {
+			container += "/tcp"
+		}
+		exposed[container] = map[string]interface{}{}
+
+		binding := map[string]string{}
+		switch len(parts) {
+		case 2:
+			binding["HostPort"] = parts[0]
+		case 3:
+			binding["HostIp"], binding["HostPort"] = parts[0], parts[1]
+		}
+		bindings[container] = append(bindings[container], binding)
+	}
+
+	binds := []string{}
+	for _, v := range s.Volumes {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) == 2 {
+			switch {
+			case strings.HasPrefix(parts[0], "."):
+				parts[0] = path.Join(e.Dir, parts[0])
+			case !strings.HasPrefix(parts[0], "/"):
+				// Named volume
+				parts[0] = e.scopedName(parts[0])
+			}
+		}
+		binds = append(binds, strings.Join(parts, ":"))
+	}
+
+	networks := keysOrList(s.Networks)
+	if len(networks) == 0 {
+		networks = []string{"default"}
+	}
+
+	links := []string{}
+	for _, l := range s.Links {
+		parts := strings.SplitN(l, ":", 2)
+		alias := parts[0]
+		if len(parts) == 2 {
+			alias = parts[1]
+		}
+		links = append(links, fmt.Sprintf("%s:%s", e.containerName(parts[0]), alias))
+	}
+
+	return map[string]interface{}{
+		"Image":        s.Image,
+		"Cmd":          s.cmd(),
+		"Env":          s.env(),
+		"ExposedPorts": exposed,
+		"Labels": map[string]string{
+			LabelProject: e.Project,
+			LabelService: name,
+		},
+		"HostConfig": map[string]interface{}{
+			"PortBindings": bindings,
+			"Binds":        binds,
+			"NetworkMode":  e.scopedName(networks[0]),
+		},
+		"NetworkingConfig": map[string]interface{}{
+			"EndpointsConfig": map[string]interface{}{
+				e.scopedName(networks[0]): map[string]interface{}{
+					"Aliases": []string{name},
+					"Links":   links,
+				},
+			},
+		},
+	}
+}
+
+func (e *Engine) ensureNetwork(network string) error {
+	existing := []struct {
+		ID string `json:"Id"`
+	}{}
+	if err := e.do(http.MethodGet, "/networks", e.labelFilter(LabelNetwork+"="+network), nil, &existing); err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return nil
+	}
+
+	return e.do(http.MethodPost, "/networks/create", nil, map[string]interface{}{
+		"Name":   e.scopedName(network),
+		"Labels": map[string]string{LabelProject: e.Project, LabelNetwork: network},
+	}, nil)
+}
+
+// upOrder returns services with their dependencies first
+func upOrder(f *composeFile, services []string) ([]string, error) {
+	result := []string{}
+	state := make(map[string]int)
+
+	var visit func(string) error
+	visit = func(s string) error {
+		svc, ok := f.Services[s]
+		if !ok {
+			return fmt.Errorf("Unknown service \"%s\"", s)
+		}
+		switch state[s] {
+		case 1:
+			return fmt.Errorf("Dependency cycle at service \"%s\"", s)
+		case 2:
+			return nil
+		}
+
+		state[s] = 1
+		for _, d := range keysOrList(svc.DependsOn) {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		state[s] = 2
+		result = append(result, s)
+		return nil
+	}
+
+	for _, s := range services {
+		if err := visit(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (e *Engine) Up(services ...string) error {
+	f, err := e.composeFile()
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		services = f.names()
+	}
+
+	order, err := upOrder(f, services)
+	if err != nil {
+		return err
+	}
+
+	existing, err := e.containers()
+	if err != nil {
+		return err
+	}
+	byService := make(map[string]engineContainer)
+	for _, c := range existing {
+		byService[c.Labels[LabelService]] = c
+	}
+
+	for _, name := range order {
+		svc := f.Services[name]
+
+		networks := keysOrList(svc.Networks)
+		if len(networks) == 0 {
+			networks = []string{"default"}
+		}
+		for _, n := range networks {
+			if err := e.ensureNetwork(n); err != nil {
+				return err
+			}
+		}
+
+		body := e.createBody(name, svc)
+		data, _ := json.Marshal(body)
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		body["Labels"].(map[string]string)[LabelConfigHash] = hash
+
+		if c, ok := byService[name]; ok {
+			if c.Labels[LabelConfigHash] == hash {
+				if c.State != "running" {
+					if err := e.do(http.MethodPost, "/containers/"+c.ID+"/start", nil, nil, nil); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			// The configuration changed, recreate the container
+			if err := e.do(http.MethodDelete, "/containers/"+c.ID, url.Values{"force": []string{"1"}}, nil, nil); err != nil {
+				return err
+			}
+		}
+
+		created := struct {
+			ID string `json:"Id"`
+		}{}
+		q := url.Values{"name": []string{e.containerName(name)}}
+		err := e.do(http.MethodPost, "/containers/create", q, body, &created)
+		if isNoSuchImage(err) {
+			// Like compose, pull missing images on up
+			if err := e.pull(svc.Image); err != nil {
+				return err
+			}
+			err = e.do(http.MethodPost, "/containers/create", q, body, &created)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, n := range networks[1:] {
+			if err := e.do(http.MethodPost, "/networks/"+e.scopedName(n)+"/connect", nil, map[string]interface{}{
+				"Container":      created.ID,
+				"EndpointConfig": map[string]interface{}{"Aliases": []string{name}},
+			}, nil); err != nil {
+				return err
+			}
+		}
+
+		if err := e.do(http.MethodPost, "/containers/"+created.ID+"/start", nil, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+const (
+	StateRunning = "running"
+	StateStopped = "exited"
+)
+
+// Fake is an in-memory Runtime for tests
+type Fake struct {
+	mu sync.Mutex
+	// Services maps the services that have a container to their state
+	Services map[string]string
+	// Images maps service names to their image, Up without services starts all of them
+	Images map[string]string
+	// Pulled are the images that have been pulled
+	Pulled []string
+	// LogLines are the lines Logs writes per service
+	LogLines map[string][]string
+	// Calls records every call like "stop nats"
+	Calls []string
+	// Fail makes calls for the given services fail
+	Fail map[string]error
+}
+
+func NewFake(running ...string) *Fake {
+	f := &Fake{
+		Services: make(map[string]string),
+		Images:   make(map[string]string),
+		Pulled:   []string{},
+		LogLines: make(map[string][]string),
+		Calls:    []string{},
+		Fail:     make(map[string]error),
+	}
+	for _, s := range running {
+		f.Services[s] = StateRunning
+	}
+
+	return f
+}
+
+// all returns services or all known services if it's empty
+func (f *Fake) all(services []string) []string {
+	if len(services) > 0 {
+		return services
+	}
+
+	known := make(map[string]bool)
+	for s := range f.Services {
+		known[s] = true
+	}
+	for s := range f.Images {
+		known[s] = true
+	}
+
+	result := []string{}
+	for s := range known {
+		result = append(result, s)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func (f *Fake) call(op string, services []string, fn func(string) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, s := range f.all(services) {
+		f.Calls = append(f.Calls, fmt.Sprintf("%s %s", op, s))
+		if err, ok := f.Fail[s]; ok {
+			return err
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *Fake) Up(services ...string) error {
+	return f.call("up", services, func(s string) error {
+		f.Services[s] = StateRunning
+		return nil
+	})
+}
+
+func (f *Fake) Down() error {
+	return f.call("down", nil, func(s string) error {
+		delete(f.Services, s)
+		return nil
+	})
+}
+
+func (f *Fake) Stop(services ...string) error {
+	return f.call("stop", services, func(s string) error {
+		if _, ok := f.Services[s]; ok {
+			f.Services[s] = StateStopped
+		}
+		return nil
+	})
+}
+
+func (f *Fake) Remove(services ...string) error {
+	return f.call("remove", services, func(s string) error {
+		if f.Services[s] == StateRunning {
+			return fmt.Errorf("Service \"%s\" is still running", s)
+		}
+		delete(f.Services, s)
+		return nil
+	})
+}
+
+func (f *Fake) Pull(services ...string) error {
+	return f.call("pull", services, func(s string) error {
+		image, ok := f.Images[s]
+		if !ok {
+			return fmt.Errorf("Unknown service \"%s\"", s)
+		}
+		f.Pulled = append(f.Pulled, image)
+		return nil
+	})
+}
+
+func (f *Fake) Ps() ([]Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := []Container{}
+	for _, s := range f.all(nil) {
+		state, ok := f.Services[s]
+		if !ok {
+			continue
+		}
+		result = append(result, Container{
+			ID:      "fake-" + s,
+			Name:    s + "_1",
+			Service: s,
+			Image:   f.Images[s],
+			State:   state,
+			Status:  state,
+		})
+	}
+
+	return result, nil
+}
+
+func (f *Fake) Logs(w io.Writer, follow bool, services ...string) error {
+	return f.call("logs", services, func(s string) error {
+		for _, l := range f.LogLines[s] {
+			if _, err := fmt.Fprintf(w, "%s | %s\n", s, l); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
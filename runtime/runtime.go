@@ -0,0 +1,68 @@
+// Package runtime abstracts the container runtime the services of the
+// installed packages run on.
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// LabelProject is the label docker-compose marks the containers of a project with
+	LabelProject = "com.docker.compose.project"
+	// LabelService is the label with the compose service name of a container
+	LabelService = "com.docker.compose.service"
+)
+
+// Container is a container of a service
+type Container struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	Image   string `json:"image"`
+	State   string `json:"state"`
+	Status  string `json:"status"`
+}
+
+// Runtime controls the services of a project, no services means all services
+type Runtime interface {
+	// Up creates and starts the given services in the background
+	Up(services ...string) error
+	// Down stops and removes all containers and networks of the project
+	Down() error
+	// Stop stops the given services
+	Stop(services ...string) error
+	// Remove removes the stopped containers of the given services
+	Remove(services ...string) error
+	// Ps lists the containers of the project
+	Ps() ([]Container, error)
+	// Logs writes the logs of the given services to w
+	Logs(w io.Writer, follow bool, services ...string) error
+	// Pull pulls the images of the given services
+	Pull(services ...string) error
+}
+
+// Names are the runtimes New knows
+var Names = []string{"compose", "engine"}
+
+// New returns the runtime name for the project in dir
+func New(name, dir string) (Runtime, error) {
+	switch name {
+	case "", "compose":
+		return NewComposeCLI(dir), nil
+	case "engine":
+		return NewEngine(dir, ProjectName(dir))
+	}
+
+	return nil, fmt.Errorf("Unknown runtime \"%s\", must be one of %v", name, Names)
+}
+
+var projectNameRe = regexp.MustCompile("[^a-z0-9_-]")
+
+// ProjectName returns the docker-compose project name of the project in dir
+func ProjectName(dir string) string {
+	return projectNameRe.ReplaceAllString(strings.ToLower(path.Base(dir)), "")
+}
@@ -0,0 +1,170 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseDockerPs(t *testing.T) {
+	out := `{"ID":"b","Image":"library/nats:2.1.9","Names":"zemm_nats_1","State":"running","Status":"Up 2 minutes","Labels":"com.docker.compose.project=zemm,com.docker.compose.service=nats"}
+{"ID":"a","Image":"minadmin/app:1.0.0","Names":"zemm_app_1","State":"exited","Status":"Exited (0)","Labels":"com.docker.compose.service=app"}
+`
+	containers, err := parseDockerPs([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 2 || containers[0].Service != "app" || containers[1].Service != "nats" || containers[1].State != "running" {
+		t.Error(fmt.Errorf("Invalid containers: %v", containers))
+	}
+}
+
+// fakeEngine is a minimal Docker Engine API
+type fakeEngine struct {
+	mu         sync.Mutex
+	containers map[string]*engineContainer
+	requests   []string
+	// images are the pulled images, all are available if nil
+	images map[string]bool
+}
+
+func (f *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.URL.Path == "/containers/json":
+		result := []engineContainer{}
+		for _, c := range f.containers {
+			result = append(result, *c)
+		}
+		json.NewEncoder(w).Encode(result)
+	case r.URL.Path == "/networks":
+		w.Write([]byte("[]"))
+	case r.URL.Path == "/networks/create":
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	case r.URL.Path == "/containers/create":
+		body := struct {
+			Image  string
+			Labels map[string]string
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if f.images != nil && !f.images[body.Image] {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "{\"message\": \"No such image: %s\"}", body.Image)
+			return
+		}
+		id := r.URL.Query().Get("name")
+		f.containers[id] = &engineContainer{ID: id, Names: []string{"/" + id}, Image: body.Image, State: "created", Labels: body.Labels}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "{\"Id\": \"%s\"}", id)
+	case r.URL.Path == "/images/create":
+		f.images[r.URL.Query().Get("fromImage")] = true
+		w.Write([]byte("{\"status\": \"Pulling\"}\n{\"status\": \"Done\"}\n"))
+	case len(parts) == 3 && parts[2] == "start":
+		f.containers[parts[1]].State = "running"
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && parts[2] == "stop":
+		f.containers[parts[1]].State = "exited"
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && len(parts) == 2:
+		delete(f.containers, parts[1])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("{\"message\": \"not found\"}"))
+	}
+}
+
+func TestEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zemmengine-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	compose := "services:\n  app:\n    image: minadmin/app:1.0.0\n    depends_on:\n      - nats\n    networks:\n      backend: {}\n  nats:\n    image: library/nats:2.1.9\n    networks:\n      backend: {}\n"
+	if err := ioutil.WriteFile(path.Join(dir, "docker-compose.yaml"), []byte(compose), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fe := &fakeEngine{containers: make(map[string]*engineContainer), images: map[string]bool{"library/nats:2.1.9": true}}
+	server := httptest.NewServer(fe)
+	defer server.Close()
+
+	e := NewEngineWithClient(dir, "zemm", server.URL, server.Client())
+	if err := e.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	containers, err := e.Ps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := []string{}
+	for _, c := range containers {
+		if c.State != "running" {
+			t.Error(fmt.Errorf("Container %s is not running", c.Name))
+		}
+		services = append(services, c.Service)
+	}
+	if !reflect.DeepEqual(services, []string{"app", "nats"}) {
+		t.Error(fmt.Errorf("Invalid services: %v", services))
+	}
+
+	// nats must be created before app
+	created := []string{}
+	for _, r := range fe.requests {
+		if strings.HasSuffix(r, "/start") {
+			created = append(created, r)
+		}
+	}
+	if !reflect.DeepEqual(created, []string{"POST /containers/zemm_nats_1/start", "POST /containers/zemm_app_1/start"}) {
+		t.Error(fmt.Errorf("Invalid start order: %v", created))
+	}
+
+	// Only the missing image gets pulled
+	pulled := []string{}
+	for _, r := range fe.requests {
+		if r == "POST /images/create" {
+			pulled = append(pulled, r)
+		}
+	}
+	if len(pulled) != 1 || !fe.images["minadmin/app:1.0.0"] {
+		t.Error(fmt.Errorf("minadmin/app:1.0.0 should have been pulled: %v", fe.requests))
+	}
+
+	// A second up must not recreate anything
+	fe.requests = []string{}
+	if err := e.Up(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range fe.requests {
+		if strings.Contains(r, "/containers/create") || strings.HasPrefix(r, "DELETE") {
+			t.Error(fmt.Errorf("Second up recreated containers: %v", fe.requests))
+			break
+		}
+	}
+
+	if err := e.Stop("app"); err != nil {
+		t.Error(err)
+	}
+	if err := e.Remove("app"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := fe.containers["zemm_app_1"]; ok || len(fe.containers) != 1 {
+		t.Error(fmt.Errorf("Only app should have been removed: %v", fe.containers))
+	}
+}
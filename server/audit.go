@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AuditEntry is a line of the audit log, written for every mutating call and
+// for the outcome of every job, those have the same Job but no request
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	Remote    string    `json:"remote,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Package   string    `json:"package,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	Job       string    `json:"job,omitempty"`
+	Operation string    `json:"operation,omitempty"`
+	// State is the state of the finished job
+	State string `json:"state,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// auditRecorder keeps the status and body of the response for the audit log
+type auditRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (r *auditRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *auditRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// newAuditEntry returns the entry for r or nil if r doesn't change anything
+func (s *Server) newAuditEntry(r *http.Request) *AuditEntry {
+	if s.Audit == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.URL.Path == "/"+APIVersion+"/resolve" {
+		return nil
+	}
+
+	e := &AuditEntry{Time: time.Now(), Remote: r.RemoteAddr, Method: r.Method, Path: r.URL.Path}
+
+	if r.Method == http.MethodDelete {
+		e.Package = strings.TrimPrefix(r.URL.Path, "/"+APIVersion+"/packages/")
+	} else if r.Body != nil {
+		// Keep the body for the handler
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			req := struct {
+				Package string `json:"package"`
+			}{}
+			if json.Unmarshal(body, &req) == nil {
+				e.Package = req.Package
+			}
+		}
+	}
+
+	return e
+}
+
+// audit completes e with the response and writes it to the audit log
+func (s *Server) audit(e *AuditEntry, rec *auditRecorder) {
+	e.Status = rec.code
+
+	resp := struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	}{}
+	if json.Unmarshal(rec.body.Bytes(), &resp) == nil {
+		e.Job = resp.ID
+		e.Error = resp.Error
+	}
+
+	s.writeAudit(e)
+}
+
+// auditJob writes the outcome of the finished job j to the audit log
+func (s *Server) auditJob(j Job) {
+	if s.Audit == nil {
+		return
+	}
+
+	e := &AuditEntry{Time: time.Now(), Package: j.Package, Job: j.ID, Operation: j.Operation, State: j.State, Error: j.Error}
+	if j.Finished != nil {
+		e.Time = *j.Finished
+	}
+
+	s.writeAudit(e)
+}
+
+func (s *Server) writeAudit(e *AuditEntry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	s.Audit.Write(append(data, '\n'))
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/pm"
+	"github.com/zemm-io/zemm/project"
+)
+
+const (
+	ScopeRead    = "read"
+	ScopeInstall = "install"
+	ScopeRemove  = "remove"
+	// ScopeAdmin allows everything including upgrades
+	ScopeAdmin = "admin"
+)
+
+// Scopes are the known scopes
+var Scopes = []string{ScopeRead, ScopeInstall, ScopeRemove, ScopeAdmin}
+
+// Token authenticates requests with "Authorization: Bearer <token>"
+type Token struct {
+	Name   string   `json:"name" yaml:"name"`
+	Token  string   `json:"token" yaml:"token"`
+	Scopes []string `json:"scopes" yaml:"scopes"`
+}
+
+// Client authenticates requests with a TLS client certificate, Name is the
+// common name of the certificate
+type Client struct {
+	Name   string   `json:"name" yaml:"name"`
+	Scopes []string `json:"scopes" yaml:"scopes"`
+}
+
+// Auth holds the credentials allowed to use the API
+type Auth struct {
+	Tokens  []Token  `json:"tokens" yaml:"tokens"`
+	Clients []Client `json:"clients" yaml:"clients"`
+}
+
+// Principal is an authenticated token or client
+type Principal struct {
+	Name   string
+	Scopes []string
+}
+
+// LoadAuth reads and verifies the auth file
+func LoadAuth(file string) (*Auth, error) {
+	a := &Auth{}
+	if err := common.URLToStruct(file, a); err != nil {
+		return nil, err
+	}
+
+	return a, a.Verify()
+}
+
+func verifyScopes(name string, scopes []string) error {
+	result := &multierror.Error{}
+	for _, s := range scopes {
+		known := false
+		for _, k := range Scopes {
+			if s == k {
+				known = true
+			}
+		}
+		if !known {
+			result = multierror.Append(result, fmt.Errorf("\"%s\" has an unknown scope \"%s\", must be one of %v", name, s, Scopes))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// Verify checks that all tokens and clients have a name and known scopes
+// and that tokens are unique
+func (a *Auth) Verify() error {
+	result := &multierror.Error{}
+
+	seen := make(map[string]int)
+	for _, t := range a.Tokens {
+		if t.Name == "" || t.Token == "" {
+			result = multierror.Append(result, fmt.Errorf("Tokens need a name and a token"))
+			continue
+		}
+		if _, ok := seen[t.Token]; ok {
+			result = multierror.Append(result, fmt.Errorf("Token \"%s\" is not unique", t.Name))
+		}
+		seen[t.Token] = 0
+		if err := verifyScopes(t.Name, t.Scopes); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	for _, c := range a.Clients {
+		if c.Name == "" {
+			result = multierror.Append(result, fmt.Errorf("Clients need a name"))
+			continue
+		}
+		if err := verifyScopes(c.Name, c.Scopes); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// Authenticate returns the principal of the bearer token or the verified
+// TLS client certificate of r
+func (a *Auth) Authenticate(r *http.Request) (*Principal, error) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		token := strings.TrimPrefix(h, "Bearer ")
+		if token == h {
+			return nil, fmt.Errorf("Only bearer tokens are supported")
+		}
+
+		for _, t := range a.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+				return &Principal{Name: "token:" + t.Name, Scopes: t.Scopes}, nil
+			}
+		}
+		return nil, fmt.Errorf("Invalid token")
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.PeerCertificates) > 0 {
+		cn := r.TLS.PeerCertificates[0].Subject.CommonName
+		for _, c := range a.Clients {
+			if c.Name == cn {
+				return &Principal{Name: "client:" + c.Name, Scopes: c.Scopes}, nil
+			}
+		}
+		return nil, fmt.Errorf("Unknown client certificate \"%s\"", cn)
+	}
+
+	return nil, fmt.Errorf("No credentials given")
+}
+
+// Allowed reports if the principal has scope, admin has all scopes
+func (p *Principal) Allowed(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requiredScope returns the scope needed for the request r
+func requiredScope(r *http.Request) string {
+	switch {
+	case r.Method == http.MethodGet || r.URL.Path == "/"+APIVersion+"/resolve":
+		return ScopeRead
+	case r.Method == http.MethodDelete:
+		return ScopeRemove
+	case r.URL.Path == "/"+APIVersion+"/upgrade":
+		return ScopeAdmin
+	}
+
+	return ScopeInstall
+}
+
+// checkAllowlist returns an error if the main list of the project doesn't
+// support all packages names
+func (s *Server) checkAllowlist(names ...string) error {
+	p, err := project.Load(s.Dir)
+	if err != nil {
+		return err
+	}
+
+	return checkList(p, p.Lists.Main, names...)
+}
+
+// checkList returns an error if list doesn't support all packages names
+func checkList(p *project.Project, list string, names ...string) error {
+	if list == "" {
+		return fmt.Errorf("No package is allowed, there is no main list")
+	}
+
+	index, err := p.Index(project.DefaultIndex)
+	if err != nil {
+		return err
+	}
+	main, err := pm.NewRepository(index, list)
+	if err != nil {
+		return err
+	}
+
+	result := &multierror.Error{}
+	for _, name := range names {
+		ok, err := main.SupportsPackage(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			result = multierror.Append(result, fmt.Errorf("Package \"%s\" is not allowed, the list \"%s\" doesn't support it", name, list))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// authorize wraps h with authentication, authorization and the audit log
+func (s *Server) authorize(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := s.newAuditEntry(r)
+		if entry != nil {
+			rec := &auditRecorder{ResponseWriter: w, code: http.StatusOK}
+			defer s.audit(entry, rec)
+			w = rec
+		}
+
+		if s.Auth == nil {
+			h(w, r)
+			return
+		}
+
+		principal, err := s.Auth.Authenticate(r)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if entry != nil {
+			entry.Principal = principal.Name
+		}
+
+		scope := requiredScope(r)
+		if !principal.Allowed(scope) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("\"%s\" lacks the scope \"%s\"", principal.Name, scope))
+			return
+		}
+
+		h(w, r)
+	}
+}
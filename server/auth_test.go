@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zemm-io/zemm/project"
+)
+
+func testAuth() *Auth {
+	return &Auth{
+		Tokens: []Token{
+			{Name: "reader", Token: "read-secret", Scopes: []string{ScopeRead}},
+			{Name: "ops", Token: "admin-secret", Scopes: []string{ScopeAdmin}},
+		},
+		Clients: []Client{{Name: "installer.example.com", Scopes: []string{ScopeRead, ScopeInstall}}},
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	s, _, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	s.Auth = testAuth()
+	audit := &bytes.Buffer{}
+	s.Audit = audit
+	h := s.Handler()
+
+	for _, c := range []struct {
+		method, url, token string
+		cn                 string
+		code               int
+	}{
+		{http.MethodGet, "/v1/packages", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/packages", "wrong", "", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/packages", "read-secret", "", http.StatusOK},
+		{http.MethodPost, "/v1/packages", "read-secret", "", http.StatusForbidden},
+		{http.MethodDelete, "/v1/packages/library/nats", "read-secret", "", http.StatusForbidden},
+		{http.MethodPost, "/v1/upgrade", "read-secret", "", http.StatusForbidden},
+		{http.MethodGet, "/v1/jobs", "admin-secret", "", http.StatusOK},
+		{http.MethodPost, "/v1/packages", "", "installer.example.com", http.StatusAccepted},
+		{http.MethodDelete, "/v1/packages/library/nats", "", "installer.example.com", http.StatusForbidden},
+		{http.MethodGet, "/v1/packages", "", "unknown.example.com", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(c.method, c.url, strings.NewReader(`{"package": "library/nats"}`))
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		if c.cn != "" {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: c.cn}}
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Error(fmt.Errorf("%s %s with \"%s%s\" returned %d instead of %d: %s", c.method, c.url, c.token, c.cn, w.Code, c.code, w.Body.String()))
+		}
+	}
+
+	for _, j := range s.jobs.list() {
+		s.jobs.wait(j.ID)
+	}
+
+	// 5 mutating calls and the outcome of the accepted install
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatal(fmt.Errorf("Expected 6 audit entries, got %d: %s", len(lines), audit.String()))
+	}
+	accepted, finished := AuditEntry{}, AuditEntry{}
+	for _, line := range lines {
+		e := AuditEntry{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Status == http.StatusAccepted {
+			accepted = e
+		}
+		if e.State != "" {
+			finished = e
+		}
+	}
+	if accepted.Principal != "client:installer.example.com" || accepted.Package != "library/nats" || accepted.Job == "" {
+		t.Error(fmt.Errorf("Invalid audit entry: %v", accepted))
+	}
+	if finished.Job != accepted.Job || finished.State != JobDone || finished.Operation != "install" {
+		t.Error(fmt.Errorf("Invalid audit entry of the finished job: %v", finished))
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	index, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(index)
+
+	postgres, err := ioutil.ReadFile("../examples/repo/lists/library/postgres/13.2.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := "info:\n  name: test\n  version: 1.0.0\n  supports:\n  - package: library/nats@2.1.9\n  - package: test/tool@1.0.0\n  - list: library/postgres/13.2\npackages:\n  - name: test/app\n    version: 1.0.0\n  - name: test/tool\n    version: 1.0.0\n    dependencies:\n      - package: test/app\n"
+	for f, content := range map[string][]byte{
+		"lists/test/app/1.0.0.yaml":        []byte(main),
+		"lists/library/postgres/13.2.yaml": postgres,
+	} {
+		if err := os.MkdirAll(path.Dir(path.Join(index, f)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(index, f), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, _, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	abs, _ := filepath.Abs(index)
+	content := fmt.Sprintf("version: 1.0\nindexes:\n  zemm: %s\nlists:\n  main: test/app/1.0.0\ninstall: []\n", abs)
+	if err := ioutil.WriteFile(path.Join(dir, project.FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, allowed := range map[string]bool{"library/nats": true, "library/postgres": true, "test/app": false, "library/mysql": false} {
+		if err := s.checkAllowlist(name); (err == nil) != allowed {
+			t.Error(fmt.Errorf("Package \"%s\" allowed should be %v: %v", name, allowed, err))
+		}
+	}
+
+	s.Allowlist = true
+	job := Job{}
+	if code := request(t, s.Handler(), http.MethodPost, "/v1/packages", project.Install{Package: "library/mysql"}, &job); code != http.StatusForbidden {
+		t.Error(fmt.Errorf("Installing a package not in the allowlist returned %d", code))
+	}
+
+	// test/tool is allowed but its dependency test/app isn't
+	_, err = s.Install(project.Install{Package: "test/tool"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "\"test/app\" is not allowed") {
+		t.Error(fmt.Errorf("Installing a package with a dependency not in the allowlist should fail: %v", err))
+	}
+}
+
+func TestAllowlistUpgrade(t *testing.T) {
+	index, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(index)
+
+	files := map[string][]byte{
+		"lists/test/app/1.0.0.yaml": []byte("info:\n  name: test\n  version: 1.0.0\n  depends:\n  - list: library/nats/2.1.9\n  supports:\n  - package: library/nats@2.1.9\npackages: []\n"),
+		"lists/test/app/1.1.0.yaml": []byte("info:\n  name: test\n  version: 1.1.0\n  depends:\n  - list: library/nats/2.1.9\npackages: []\n"),
+	}
+	for _, f := range []string{"lists/library/nats/2.1.9.yaml", "packages/library/nats/2.1.9.txz"} {
+		if files[f], err = ioutil.ReadFile(path.Join("../examples/repo", f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for f, content := range files {
+		if err := os.MkdirAll(path.Dir(path.Join(index, f)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(index, f), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, _, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	abs, _ := filepath.Abs(index)
+	content := fmt.Sprintf("version: 1.0\nindexes:\n  zemm: %s\nlists:\n  main: test/app/1.0.0\ninstall: []\n", abs)
+	if err := ioutil.WriteFile(path.Join(dir, project.FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Allowlist = true
+	if _, err := s.Install(project.Install{Package: "library/nats"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// test/app/1.1.0 doesn't support library/nats anymore
+	_, err = s.Upgrade(nil)
+	if err == nil || !strings.Contains(err.Error(), "\"library/nats\" is not allowed") {
+		t.Error(fmt.Errorf("Upgrading to a list that doesn't support an installed package should fail: %v", err))
+	}
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Lists.Main != "test/app/1.0.0" {
+		t.Error(fmt.Errorf("The main list should not be upgraded: %s", p.Lists.Main))
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zemm-io/zemm/install"
+)
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+const (
+	// JobTTL is how long finished jobs are kept
+	JobTTL = 24 * time.Hour
+	// MaxFinishedJobs is the number of finished jobs kept, the oldest get evicted first
+	MaxFinishedJobs = 100
+)
+
+// Job is a long running operation, clients poll it by its ID
+type Job struct {
+	ID        string          `json:"id"`
+	Operation string          `json:"operation"`
+	Package   string          `json:"package,omitempty"`
+	State     string          `json:"state"`
+	Error     string          `json:"error,omitempty"`
+	Result    *install.Result `json:"result,omitempty"`
+	Created   time.Time       `json:"created"`
+	Finished  *time.Time      `json:"finished,omitempty"`
+}
+
+type jobs struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	done map[string]chan struct{}
+	// finished gets called with every finished job before waiters return
+	finished func(Job)
+}
+
+func newJobs() *jobs {
+	return &jobs{jobs: make(map[string]*Job), done: make(map[string]chan struct{})}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// start runs fn with the ID of a new job in the background and returns a copy of the job
+func (js *jobs) start(operation, pkg string, fn func(id string) (*install.Result, error)) Job {
+	js.mu.Lock()
+	j := &Job{ID: newJobID(), Operation: operation, Package: pkg, State: JobPending, Created: time.Now()}
+	js.jobs[j.ID] = j
+	js.done[j.ID] = make(chan struct{})
+	started := *j
+	js.mu.Unlock()
+
+	go func() {
+		js.update(j.ID, func(j *Job) { j.State = JobRunning })
+		result, err := fn(j.ID)
+		js.update(j.ID, func(j *Job) {
+			now := time.Now()
+			j.Finished = &now
+			j.Result = result
+			j.State = JobDone
+			if err != nil {
+				j.State = JobFailed
+				j.Error = err.Error()
+			}
+		})
+		if js.finished != nil {
+			if finished, ok := js.get(j.ID); ok {
+				js.finished(finished)
+			}
+		}
+
+		js.mu.Lock()
+		close(js.done[j.ID])
+		js.evict(time.Now())
+		js.mu.Unlock()
+	}()
+
+	return started
+}
+
+// evict removes the finished jobs older than JobTTL and the oldest ones
+// beyond MaxFinishedJobs, js.mu must be held
+func (js *jobs) evict(now time.Time) {
+	finished := []*Job{}
+	for id, j := range js.jobs {
+		if j.Finished == nil {
+			continue
+		}
+		if now.Sub(*j.Finished) > JobTTL {
+			delete(js.jobs, id)
+			delete(js.done, id)
+			continue
+		}
+		finished = append(finished, j)
+	}
+
+	sort.Slice(finished, func(i, k int) bool {
+		return finished[i].Finished.Before(*finished[k].Finished)
+	})
+	for len(finished) > MaxFinishedJobs {
+		delete(js.jobs, finished[0].ID)
+		delete(js.done, finished[0].ID)
+		finished = finished[1:]
+	}
+}
+
+func (js *jobs) update(id string, fn func(*Job)) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	fn(js.jobs[id])
+}
+
+// get returns a copy of the job with the given id
+func (js *jobs) get(id string) (Job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	j, ok := js.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+
+	return *j, true
+}
+
+// list returns copies of all jobs, oldest first
+func (js *jobs) list() []Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	result := []Job{}
+	for _, j := range js.jobs {
+		result = append(result, *j)
+	}
+	sort.Slice(result, func(i, k int) bool {
+		return result[i].Created.Before(result[k].Created)
+	})
+
+	return result
+}
+
+// wait blocks until the job with the given id is finished
+func (js *jobs) wait(id string) (Job, bool) {
+	js.mu.Lock()
+	done, ok := js.done[id]
+	js.mu.Unlock()
+	if !ok {
+		return Job{}, false
+	}
+
+	<-done
+	return js.get(id)
+}
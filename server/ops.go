@@ -0,0 +1,323 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/zemm-io/zemm/compose"
+	"github.com/zemm-io/zemm/events"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/project"
+)
+
+// change applies edit to the project and installs the result, on failure
+// the zemm files are restored. If the services fail to start the install
+// gets reverted.
+func (s *Server) change(sink events.Sink, edit func(p *project.Project, i *install.Installer) error) (*install.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := project.Load(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := p.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	previous, err := install.CurrentGeneration(p.Dir())
+	if err != nil {
+		return nil, err
+	}
+
+	i := install.NewInstaller(p)
+	i.AllowEmpty = true
+	i.Events = sink
+
+	result, err := func() (*install.Result, error) {
+		if err := edit(p, i); err != nil {
+			sink.Emit(events.Event{Type: events.Error, Message: err.Error()})
+			return nil, err
+		}
+		return i.Run()
+	}()
+	if err != nil {
+		if rErr := snapshot.Restore(); rErr != nil {
+			return nil, multierror.Append(err, rErr)
+		}
+		return nil, err
+	}
+
+	if err := s.up(p, sink); err != nil {
+		return nil, s.revert(previous, snapshot, err, sink)
+	}
+
+	return result, nil
+}
+
+// revert undoes a committed change that failed with err: it tears down the
+// services of the packages the change added, switches back to generation
+// previous, restores the zemm files and starts the previous services again
+func (s *Server) revert(previous int, snapshot project.Snapshot, err error, sink events.Sink) error {
+	result := multierror.Append(&multierror.Error{}, err)
+
+	p, pErr := project.Load(s.Dir)
+	if pErr != nil {
+		return multierror.Append(result, pErr)
+	}
+	lock, lErr := install.ReadLock(p.Dir())
+	if lErr != nil {
+		return multierror.Append(result, lErr)
+	}
+	old := &install.Lock{}
+	if previous != 0 {
+		if old, lErr = install.ReadLock(install.GenerationDir(p.Dir(), previous)); lErr != nil {
+			return multierror.Append(result, lErr)
+		}
+	}
+
+	added := []string{}
+	for _, n := range compose.ReverseOrder(lock) {
+		if _, ok := old.Package(n); !ok {
+			added = append(added, n)
+		}
+	}
+	g, gErr := compose.NewProjectGenerator(p, lock, false)
+	if gErr == nil {
+		_, gErr = compose.Down(s.Runtime, g, added)
+	}
+	if gErr != nil {
+		result = multierror.Append(result, gErr)
+	}
+
+	if rErr := install.Restore(p.Dir(), previous); rErr != nil {
+		return multierror.Append(result, rErr)
+	}
+	if rErr := snapshot.Restore(); rErr != nil {
+		return multierror.Append(result, rErr)
+	}
+	sink.Emit(events.Event{Type: events.Error, Message: fmt.Sprintf("Reverted to generation %d", previous)})
+
+	p, pErr = project.Load(s.Dir)
+	if pErr != nil {
+		return multierror.Append(result, pErr)
+	}
+	if uErr := s.up(p, sink); uErr != nil {
+		result = multierror.Append(result, uErr)
+	}
+
+	return result
+}
+
+// up writes the compose file of the installed packages and starts their
+// services
+func (s *Server) up(p *project.Project, sink events.Sink) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("Failed to start the services: %v", err)
+			sink.Emit(events.Event{Type: events.Error, Message: err.Error()})
+		}
+	}()
+
+	lock, err := install.ReadLock(p.Dir())
+	if err != nil {
+		return err
+	}
+	if len(lock.Packages) == 0 {
+		return nil
+	}
+
+	if err := compose.Write(p, lock); err != nil {
+		return err
+	}
+
+	g, err := compose.NewProjectGenerator(p, lock, false)
+	if err != nil {
+		return err
+	}
+
+	services := []string{}
+	for _, lp := range lock.Packages {
+		services = append(services, g.Services(lp.Name)...)
+	}
+	if len(services) == 0 {
+		return nil
+	}
+
+	if err := s.Runtime.Up(services...); err != nil {
+		return err
+	}
+	for _, svc := range services {
+		sink.Emit(events.Event{Type: events.ServiceStarted, Service: svc})
+	}
+
+	return nil
+}
+
+// Install adds inst to the project, installs it and starts its services,
+// with the allowlist every resolved package must be allowed
+func (s *Server) Install(inst project.Install, sink events.Sink) (*install.Result, error) {
+	return s.change(sink, func(p *project.Project, i *install.Installer) error {
+		if err := p.AddInstall(inst); err != nil {
+			return err
+		}
+		if !s.Allowlist {
+			return nil
+		}
+
+		plan, err := i.Plan()
+		if err != nil {
+			return err
+		}
+		names := []string{}
+		for _, pkg := range plan.Packages {
+			names = append(names, pkg.Name)
+		}
+		return s.checkAllowlist(names...)
+	})
+}
+
+// Remove removes package name and the dependencies nothing else needs from
+// the project and tears down their services, if that fails the removal gets
+// reverted
+func (s *Server) Remove(name string, sink events.Sink) (*install.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := project.Load(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := install.ReadLock(p.Dir())
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := p.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	previous, err := install.CurrentGeneration(p.Dir())
+	if err != nil {
+		return nil, err
+	}
+
+	i := install.NewInstaller(p)
+	i.Events = sink
+	var down func() error
+	result, err := func() (*install.Result, error) {
+		plan, err := i.Remove([]string{name}, true)
+		if err != nil {
+			return nil, err
+		}
+		// The generator needs the files of the current generation, it is
+		// created before the removal switches to the next one
+		g, err := compose.NewProjectGenerator(p, lock, false)
+		if err != nil {
+			return nil, err
+		}
+		down = func() error {
+			_, err := compose.Down(s.Runtime, g, removedOrder(lock, plan))
+			return err
+		}
+		return i.Remove([]string{name}, false)
+	}()
+	if err != nil {
+		sink.Emit(events.Event{Type: events.Error, Message: err.Error()})
+		return nil, err
+	}
+
+	if err := down(); err != nil {
+		sink.Emit(events.Event{Type: events.Error, Message: err.Error()})
+		return nil, s.revert(previous, snapshot, err, sink)
+	}
+	if err := s.up(p, sink); err != nil {
+		return nil, s.revert(previous, snapshot, err, sink)
+	}
+
+	return result, nil
+}
+
+// removedOrder returns the packages plan removes from lock in the order
+// their services get torn down
+func removedOrder(lock *install.Lock, plan *install.Result) []string {
+	removed := make(map[string]bool)
+	for _, c := range plan.Removed {
+		removed[c.Name] = true
+	}
+
+	result := []string{}
+	for _, n := range compose.ReverseOrder(lock) {
+		if removed[n] {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// checkUpgrade returns an error if the upgraded main list doesn't support
+// all packages the upgrade resolves
+func (s *Server) checkUpgrade(p *project.Project, i *install.Installer, upgrades []install.ListUpgrade) error {
+	plan, err := i.PlanUpgrade(upgrades)
+	if err != nil {
+		return err
+	}
+
+	main := p.Lists.Main
+	for _, u := range upgrades {
+		if u.From == main {
+			main = u.To
+		}
+	}
+	names := []string{}
+	for _, pkg := range plan.Packages {
+		names = append(names, pkg.Name)
+	}
+
+	return checkList(p, main, names...)
+}
+
+// Upgrade upgrades all lists of the project to their newest compatible
+// versions, with the allowlist the upgraded main list must support every
+// resolved package. If the services fail to start the upgrade gets reverted
+func (s *Server) Upgrade(sink events.Sink) (*install.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := project.Load(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := p.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	previous, err := install.CurrentGeneration(p.Dir())
+	if err != nil {
+		return nil, err
+	}
+
+	i := install.NewInstaller(p)
+	i.AllowEmpty = true
+	i.Events = sink
+	upgrades, err := i.ListUpgrades()
+	if err != nil {
+		return nil, err
+	}
+	if s.Allowlist {
+		if err := s.checkUpgrade(p, i, upgrades); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := i.Upgrade(upgrades)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.up(p, sink); err != nil {
+		return nil, s.revert(previous, snapshot, err, sink)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,378 @@
+// Package server implements the HTTP/JSON API of "zemm serve" which lets
+// other services install and remove packages at runtime.
+//
+// All routes are prefixed with the API version, e.g. /v1/packages:
+//
+//	GET    /v1/status            installed packages with the state of their services
+//	GET    /v1/packages          the installed packages
+//	POST   /v1/packages          install {"package": "ns/name", "overlay": false}, returns a job
+//	DELETE /v1/packages/ns/name  remove a package, returns a job
+//	POST   /v1/resolve           resolve {"packages": [...]} without installing
+//	POST   /v1/upgrade           upgrade the lists, returns a job
+//	GET    /v1/jobs[/id]         the jobs of install, remove and upgrade
+//	GET    /v1/events[?job=id]   server-sent events of the jobs
+//
+// Requests authenticate with a bearer token or a TLS client certificate, GET
+// and resolve need the scope "read", installs "install", removals "remove"
+// and upgrades "admin".
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/zemm-io/zemm/common"
+	"github.com/zemm-io/zemm/compose"
+	"github.com/zemm-io/zemm/events"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/pm"
+	"github.com/zemm-io/zemm/project"
+	"github.com/zemm-io/zemm/runtime"
+)
+
+// APIVersion prefixes all routes
+const APIVersion = "v1"
+
+type Server struct {
+	// Dir is the project directory
+	Dir     string
+	Runtime runtime.Runtime
+	// Auth are the credentials allowed to use the API, without it the API is open
+	Auth *Auth
+	// Allowlist restricts installs to packages the main list supports
+	Allowlist bool
+	// Audit receives an AuditEntry as JSON line for every mutating call and job outcome
+	Audit io.Writer
+
+	// mu serializes changes to the project
+	mu      sync.Mutex
+	auditMu sync.Mutex
+	jobs    *jobs
+	events  *events.Bus
+}
+
+func New(dir string, rt runtime.Runtime) *Server {
+	s := &Server{Dir: dir, Runtime: rt, jobs: newJobs(), events: events.NewBus()}
+	s.jobs.finished = s.auditJob
+	return s
+}
+
+// jobEvents returns the sink publishing the events of job id
+func (s *Server) jobEvents(id string) events.Sink {
+	publish := s.events.Sink()
+	return func(e events.Event) {
+		e.Job = id
+		publish(e)
+	}
+}
+
+type ResolveRequest struct {
+	// Packages to resolve, defaults to the packages of the project
+	Packages   []string `json:"packages"`
+	Recommends *bool    `json:"recommends,omitempty"`
+}
+
+type ResolvedPackage struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	List     string   `json:"list"`
+	Provides []string `json:"provides,omitempty"`
+	// Recommended is set if the package is only needed because of recommends
+	Recommended bool `json:"recommended,omitempty"`
+	// Preferred is the entry of "prefer" that selected the package as provider
+	Preferred string `json:"preferred,omitempty"`
+}
+
+type ResolveResponse struct {
+	Packages []ResolvedPackage `json:"packages"`
+	Warnings []string          `json:"warnings"`
+}
+
+type StatusResponse struct {
+	Packages  []compose.PackageStatus `json:"packages"`
+	Unmanaged []runtime.Container     `json:"unmanaged"`
+	Jobs      []Job                   `json:"jobs"`
+}
+
+// Handler returns the http.Handler serving the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	prefix := "/" + APIVersion
+
+	mux.HandleFunc(prefix+"/status", s.authorize(s.handleStatus))
+	mux.HandleFunc(prefix+"/packages", s.authorize(s.handlePackages))
+	mux.HandleFunc(prefix+"/packages/", s.authorize(s.handlePackage))
+	mux.HandleFunc(prefix+"/resolve", s.authorize(s.handleResolve))
+	mux.HandleFunc(prefix+"/upgrade", s.authorize(s.handleUpgrade))
+	mux.HandleFunc(prefix+"/jobs", s.authorize(s.handleJobs))
+	mux.HandleFunc(prefix+"/jobs/", s.authorize(s.handleJob))
+	mux.HandleFunc(prefix+"/events", s.authorize(s.handleEvents))
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+	return false
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	p, err := project.Load(s.Dir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	lock, err := install.ReadLock(p.Dir())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	g, err := compose.NewProjectGenerator(p, lock, true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	status, unmanaged, err := compose.Status(g, lock, s.Runtime)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, StatusResponse{Packages: status, Unmanaged: unmanaged, Jobs: s.jobs.list()})
+}
+
+func (s *Server) handlePackages(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		state, err := install.ReadState(s.Dir)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, state.Packages)
+		return
+	}
+
+	inst := project.Install{}
+	if err := json.NewDecoder(r.Body).Decode(&inst); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if inst.Package == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("No package given"))
+		return
+	}
+	if s.Allowlist {
+		if err := s.checkAllowlist(inst.Package); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+	}
+
+	job := s.jobs.start("install", inst.Package, func(id string) (*install.Result, error) {
+		return s.Install(inst, s.jobEvents(id))
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handlePackage(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/"+APIVersion+"/packages/")
+	if r.Method == http.MethodGet {
+		state, err := install.ReadState(s.Dir)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		ip, ok := state.Package(name)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("Package \"%s\" is not installed", name))
+			return
+		}
+		writeJSON(w, http.StatusOK, ip)
+		return
+	}
+
+	job := s.jobs.start("remove", name, func(id string) (*install.Result, error) {
+		return s.Remove(name, s.jobEvents(id))
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	req := ResolveRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	p, err := project.Load(s.Dir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	i := install.NewInstaller(p)
+	if req.Recommends != nil {
+		i.Recommends = *req.Recommends
+	}
+	requests := i.Requests()
+	if len(req.Packages) > 0 {
+		requests = []pm.Request{}
+		for _, name := range req.Packages {
+			requests = append(requests, pm.Request{Package: name, Recommends: i.Recommends})
+		}
+	}
+
+	mgr, err := i.NewPackageManager()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	pkgs, rErr := mgr.GetDependenciesFor(requests, p.Without)
+	warnings, err := common.SplitWarnings(rErr.ErrorOrNil())
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	recommended := make(map[string]bool)
+	for _, name := range mgr.RecommendsOnly() {
+		recommended[name] = true
+	}
+
+	preferred := make(map[string]string)
+	for _, d := range mgr.Preferred() {
+		preferred[d.Package] = d.Preference
+	}
+
+	resp := ResolveResponse{Packages: []ResolvedPackage{}, Warnings: []string{}}
+	for _, pkg := range pkgs {
+		resp.Packages = append(resp.Packages, ResolvedPackage{
+			Name:        pkg.Name,
+			Version:     i.PackageVersion(pkg),
+			List:        pkg.Repository.GetList(),
+			Provides:    pkg.ProvidedNames(),
+			Recommended: recommended[pkg.Name],
+			Preferred:   preferred[pkg.Name],
+		})
+	}
+	for _, warn := range warnings {
+		resp.Warnings = append(resp.Warnings, warn.Error())
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	job := s.jobs.start("upgrade", "", func(id string) (*install.Result, error) {
+		return s.Upgrade(s.jobEvents(id))
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.jobs.list())
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/"+APIVersion+"/jobs/")
+	job, ok := s.jobs.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Unknown job \"%s\"", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("Streaming is not supported"))
+		return
+	}
+
+	ch, cancel := s.events.Subscribe()
+	defer cancel()
+
+	job := r.URL.Query().Get("job")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if job != "" && e.Job != job {
+				continue
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
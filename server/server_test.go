@@ -0,0 +1,281 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zemm-io/zemm/events"
+	"github.com/zemm-io/zemm/install"
+	"github.com/zemm-io/zemm/project"
+	"github.com/zemm-io/zemm/runtime"
+)
+
+func newTestServer(t *testing.T) (*Server, *runtime.Fake, string) {
+	dir, err := ioutil.TempDir("", "zemmtest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := filepath.Abs("../examples/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := fmt.Sprintf("version: 1.0\nindexes:\n  zemm: %s\nlists:\n  main: library/nats/2.1.9\ninstall: []\n", repo)
+	if err := ioutil.WriteFile(path.Join(dir, project.FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rt := runtime.NewFake()
+	return New(dir, rt), rt, dir
+}
+
+func request(t *testing.T, h http.Handler, method, url string, body interface{}, v interface{}) int {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, url, bytes.NewReader(data)))
+
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatal(fmt.Errorf("%s %s: %v: %s", method, url, err, w.Body.String()))
+		}
+	}
+
+	return w.Code
+}
+
+func TestInstallAndRemove(t *testing.T) {
+	s, rt, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	h := s.Handler()
+
+	job := Job{}
+	if code := request(t, h, http.MethodPost, "/v1/packages", project.Install{Package: "library/nats"}, &job); code != http.StatusAccepted {
+		t.Fatal(fmt.Errorf("Install returned %d", code))
+	}
+	if job, _ = s.jobs.wait(job.ID); job.State != JobDone {
+		t.Fatal(fmt.Errorf("Install failed: %s", job.Error))
+	}
+
+	pkgs := []install.InstalledPackage{}
+	request(t, h, http.MethodGet, "/v1/packages", nil, &pkgs)
+	if len(pkgs) != 1 || pkgs[0].Name != "library/nats" || pkgs[0].Reason != install.ReasonExplicit {
+		t.Error(fmt.Errorf("Invalid installed packages: %v", pkgs))
+	}
+	if len(rt.Calls) != 1 || rt.Calls[0] != "up nats" {
+		t.Error(fmt.Errorf("Services have not been started: %v", rt.Calls))
+	}
+
+	status := StatusResponse{}
+	request(t, h, http.MethodGet, "/v1/status", nil, &status)
+	if len(status.Packages) != 1 || len(status.Packages[0].Services[0].Containers) != 1 {
+		t.Error(fmt.Errorf("Invalid status: %v", status))
+	}
+
+	if code := request(t, h, http.MethodDelete, "/v1/packages/library/nats", nil, &job); code != http.StatusAccepted {
+		t.Fatal(fmt.Errorf("Remove returned %d", code))
+	}
+	if job, _ = s.jobs.wait(job.ID); job.State != JobDone {
+		t.Fatal(fmt.Errorf("Remove failed: %s", job.Error))
+	}
+
+	request(t, h, http.MethodGet, "/v1/packages", nil, &pkgs)
+	if len(pkgs) != 0 {
+		t.Error(fmt.Errorf("Package has not been removed: %v", pkgs))
+	}
+	if len(rt.Services) != 0 {
+		t.Error(fmt.Errorf("Services have not been removed: %v", rt.Services))
+	}
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Install) != 0 {
+		t.Error(fmt.Errorf("Package is still in %s: %v", project.FileName, p.Install))
+	}
+}
+
+func TestInstallFailureRestoresProject(t *testing.T) {
+	s, _, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+
+	if _, err := s.Install(project.Install{Package: "library/unknown"}, nil); err == nil {
+		t.Error(fmt.Errorf("Installing an unknown package should fail"))
+	}
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Install) != 0 {
+		t.Error(fmt.Errorf("%s has not been restored: %v", project.FileName, p.Install))
+	}
+}
+
+func TestInstallStartFailure(t *testing.T) {
+	s, rt, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	rt.Fail["nats"] = fmt.Errorf("no space left")
+
+	job := s.jobs.start("install", "library/nats", func(id string) (*install.Result, error) {
+		return s.Install(project.Install{Package: "library/nats"}, s.jobEvents(id))
+	})
+	job, _ = s.jobs.wait(job.ID)
+	if job.State != JobFailed || !strings.Contains(job.Error, "no space left") {
+		t.Error(fmt.Errorf("The install should fail: %v", job))
+	}
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Install) != 0 {
+		t.Error(fmt.Errorf("library/nats should not stay installed: %v", p.Install))
+	}
+	if n, err := install.CurrentGeneration(dir); err != nil || n != 0 {
+		t.Error(fmt.Errorf("The install should be rolled back, current generation is %d: %v", n, err))
+	}
+}
+
+func TestRemoveFailure(t *testing.T) {
+	s, rt, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+
+	if _, err := s.Install(project.Install{Package: "library/nats"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	rt.Fail["nats"] = fmt.Errorf("device busy")
+
+	if _, err := s.Remove("library/nats", nil); err == nil || !strings.Contains(err.Error(), "device busy") {
+		t.Error(fmt.Errorf("The removal should fail: %v", err))
+	}
+
+	p, err := project.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Install) != 1 {
+		t.Error(fmt.Errorf("library/nats should stay installed: %v", p.Install))
+	}
+	if n, err := install.CurrentGeneration(dir); err != nil || n != 1 {
+		t.Error(fmt.Errorf("The removal should be rolled back, current generation is %d: %v", n, err))
+	}
+	if last := rt.Calls[len(rt.Calls)-1]; last != "up nats" {
+		t.Error(fmt.Errorf("The services should be started again: %v", rt.Calls))
+	}
+}
+
+func TestResolveAndJobs(t *testing.T) {
+	s, _, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	h := s.Handler()
+
+	resp := ResolveResponse{}
+	if code := request(t, h, http.MethodPost, "/v1/resolve", ResolveRequest{Packages: []string{"library/nats"}}, &resp); code != http.StatusOK {
+		t.Fatal(fmt.Errorf("Resolve returned %d", code))
+	}
+	if len(resp.Packages) != 1 || resp.Packages[0].Version != "2.1.9" || resp.Packages[0].List != "library/nats/2.1.9" {
+		t.Error(fmt.Errorf("Invalid resolved packages: %v", resp.Packages))
+	}
+
+	errResp := map[string]string{}
+	if code := request(t, h, http.MethodGet, "/v1/jobs/unknown", nil, &errResp); code != http.StatusNotFound || errResp["error"] == "" {
+		t.Error(fmt.Errorf("Unknown job returned %d: %v", code, errResp))
+	}
+	if code := request(t, h, http.MethodPut, "/v1/packages", nil, nil); code != http.StatusMethodNotAllowed {
+		t.Error(fmt.Errorf("PUT returned %d", code))
+	}
+}
+
+func TestJobEviction(t *testing.T) {
+	js := newJobs()
+	now := time.Now()
+	for n := 0; n < MaxFinishedJobs+5; n++ {
+		finished := now.Add(time.Duration(n-MaxFinishedJobs) * time.Minute)
+		id := fmt.Sprintf("job%d", n)
+		js.jobs[id] = &Job{ID: id, State: JobDone, Finished: &finished}
+	}
+	old := now.Add(-JobTTL - time.Minute)
+	js.jobs["old"] = &Job{ID: "old", State: JobFailed, Finished: &old}
+	js.jobs["running"] = &Job{ID: "running", State: JobRunning}
+
+	js.evict(now)
+	if len(js.jobs) != MaxFinishedJobs+1 {
+		t.Error(fmt.Errorf("Expected %d jobs, got %d", MaxFinishedJobs+1, len(js.jobs)))
+	}
+	for _, id := range []string{"old", "job0", "job4"} {
+		if _, ok := js.get(id); ok {
+			t.Error(fmt.Errorf("Job %s should have been evicted", id))
+		}
+	}
+	for _, id := range []string{"running", "job5"} {
+		if _, ok := js.get(id); !ok {
+			t.Error(fmt.Errorf("Job %s should have been kept", id))
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s, _, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/v1/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatal(fmt.Errorf("Invalid content type \"%s\"", ct))
+	}
+
+	job := Job{}
+	if code := request(t, s.Handler(), http.MethodPost, "/v1/packages", project.Install{Package: "library/nats"}, &job); code != http.StatusAccepted {
+		t.Fatal(fmt.Errorf("Install returned %d", code))
+	}
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() && !seen[events.ServiceStarted] {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		e := events.Event{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Job != job.ID {
+			t.Error(fmt.Errorf("Event %v has not the job ID %s", e, job.ID))
+		}
+		seen[e.Type] = true
+	}
+
+	for _, typ := range []string{events.ListFetched, events.PackageResolved, events.DownloadProgress, events.ExtractDone, events.ServiceStarted} {
+		if !seen[typ] {
+			t.Error(fmt.Errorf("No %s event received: %v", typ, seen))
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package subst replaces ${VARIABLE} references in the compose fragments of packages.
+//
+// Supported syntax:
+//
+//	${NAME}           value of NAME, it's an error if NAME is not set
+//	${NAME:-default}  default if NAME is not set or empty
+//	${NAME-default}   default if NAME is not set
+//	${NAME:?message}  error with message if NAME is not set or empty
+//	${NAME?message}   error with message if NAME is not set
+//	$${NAME}          escaped, results in ${NAME} for docker-compose
+//
+// Everything else including "$$" and "$NAME" is left untouched.
+package subst
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+type Vars map[string]string
+
+// BuiltinVars returns the variables every package gets
+func BuiltinVars(name, version, packageDir string) Vars {
+	v := Vars{
+		"VERSION":     version,
+		"NAME":        name,
+		"NAMESPACE":   "",
+		"PACKAGE_DIR": packageDir,
+	}
+	if strings.Contains(name, "/") {
+		exp := strings.SplitN(name, "/", 2)
+		v["NAMESPACE"] = exp[0]
+		v["NAME"] = exp[1]
+	}
+
+	return v
+}
+
+// Merge returns a copy of v with all variables of others added, later ones win
+func (v Vars) Merge(others ...Vars) Vars {
+	result := Vars{}
+	for k, val := range v {
+		result[k] = val
+	}
+	for _, o := range others {
+		for k, val := range o {
+			result[k] = val
+		}
+	}
+
+	return result
+}
+
+// operator returns the index and the first operator of expr, the name in
+// front of it must not be empty
+func operator(expr string) (int, string) {
+	for i := 1; i < len(expr); i++ {
+		switch {
+		case expr[i] == '-' || expr[i] == '?':
+			return i, expr[i : i+1]
+		case expr[i] == ':' && i+1 < len(expr) && (expr[i+1] == '-' || expr[i+1] == '?'):
+			return i, expr[i : i+2]
+		}
+	}
+
+	return -1, ""
+}
+
+func (v Vars) expand(expr string) (string, error) {
+	if i, op := operator(expr); i > 0 {
+		name, arg := expr[:i], expr[i+len(op):]
+		val, ok := v[name]
+		set := ok
+		if op[0] == ':' {
+			set = ok && val != ""
+		}
+		if set {
+			return val, nil
+		}
+
+		if op == ":-" || op == "-" {
+			return arg, nil
+		}
+		if arg == "" {
+			arg = "required variable is not set"
+		}
+		return "", fmt.Errorf("%s: %s", name, arg)
+	}
+
+	val, ok := v[expr]
+	if !ok {
+		return "", fmt.Errorf("Unknown variable \"%s\"", expr)
+	}
+
+	return val, nil
+}
+
+// Substitute replaces all variable references in input
+func Substitute(input string, vars Vars) (string, error) {
+	result := &multierror.Error{}
+	var out strings.Builder
+
+	for i := 0; i < len(input); i++ {
+		if input[i] != '$' {
+			out.WriteByte(input[i])
+			continue
+		}
+
+		// Escaped "$${" becomes "${", every other "$$" stays
+		if strings.HasPrefix(input[i:], "$${") {
+			out.WriteString("${")
+			i += 2
+			continue
+		}
+		if strings.HasPrefix(input[i:], "$$") {
+			out.WriteString("$$")
+			i++
+			continue
+		}
+
+		if !strings.HasPrefix(input[i:], "${") {
+			out.WriteByte('$')
+			continue
+		}
+
+		end := strings.IndexByte(input[i:], '}')
+		if end < 0 {
+			result = multierror.Append(result, fmt.Errorf("Unterminated variable reference at offset %d", i))
+			out.WriteString(input[i:])
+			break
+		}
+
+		val, err := vars.expand(input[i+2 : i+end])
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+		out.WriteString(val)
+		i += end
+	}
+
+	return out.String(), result.ErrorOrNil()
+}
+
+// File returns the contents of file with all variable references replaced
+func File(file string, vars Vars) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := Substitute(string(data), vars)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path.Base(file), err)
+	}
+
+	return []byte(out), nil
+}
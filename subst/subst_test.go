@@ -0,0 +1,61 @@
+package subst
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSubstitute(t *testing.T) {
+	vars := BuiltinVars("library/nats", "2.1.9", "/tmp/nats").Merge(Vars{"EMPTY": ""})
+
+	tests := map[string]string{
+		"image: library/nats:${VERSION}": "image: library/nats:2.1.9",
+		"${NAMESPACE}/${NAME}":           "library/nats",
+		"${PACKAGE_DIR}/conf":            "/tmp/nats/conf",
+		"${PORT:-4222}":                  "4222",
+		"${EMPTY:-default}":              "default",
+		"${EMPTY-default}":               "",
+		"${X-a:?b}":                      "a:?b",
+		"${X:-a-b?c}":                    "a-b?c",
+		"${VERSION?set up-front}":        "2.1.9",
+		"$${HOME} and $$HOME and $HOME":  "${HOME} and $$HOME and $HOME",
+		"cost: 5$":                       "cost: 5$",
+	}
+
+	for in, expected := range tests {
+		out, err := Substitute(in, vars)
+		if err != nil {
+			t.Error(err)
+		}
+		if out != expected {
+			t.Error(fmt.Errorf("Substitute(%q) = %q, expected %q", in, out, expected))
+		}
+	}
+}
+
+func TestSubstituteErrors(t *testing.T) {
+	vars := BuiltinVars("library/nats", "2.1.9", "/tmp/nats")
+
+	for _, in := range []string{"${UNKNOWN}", "${PASSWORD:?set a password}", "${VERSION"} {
+		if _, err := Substitute(in, vars); err == nil {
+			t.Error(fmt.Errorf("Substitute(%q) should fail", in))
+		}
+	}
+
+	// The message may contain other operators
+	_, err := Substitute("${PASSWORD?set up-front}", vars)
+	if err == nil || !strings.Contains(err.Error(), "PASSWORD: set up-front") {
+		t.Error(fmt.Errorf("Substitute should fail with the message, got %v", err))
+	}
+}
+
+func TestSubstituteFile(t *testing.T) {
+	out, err := File("../examples/apps/library/nats/zemmpkg/compose.yaml", BuiltinVars("library/nats", "2.1.9", ""))
+	if err != nil {
+		t.Error(err)
+	}
+	if string(out) == "" {
+		t.Error(fmt.Errorf("Empty result"))
+	}
+}